@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Shared helpers for the REST based calendar backends (Google Calendar, Microsoft Graph).
+
+const calendarAPITimeout = 5 * time.Second
+
+var calendarHTTPClient = &http.Client{Timeout: calendarAPITimeout}
+
+type accessToken struct {
+	Value  string
+	Expiry time.Time
+}
+
+// accessTokens caches OAuth2 access tokens across requests, because a backend
+// instance only lives for a single IsActive/GetMetrics call. Tokens are fetched
+// without holding the lock, one fetch at a time per key, so a slow token
+// endpoint only delays the triggers that use it.
+var accessTokens = struct {
+	sync.Mutex
+	tokens   map[string]accessToken
+	fetching map[string]*sync.Mutex
+}{tokens: map[string]accessToken{}, fetching: map[string]*sync.Mutex{}}
+
+// cachedAccessToken returns the token for key unless it is missing or about to
+// expire.
+func cachedAccessToken(key string) (string, bool) {
+	accessTokens.Lock()
+	defer accessTokens.Unlock()
+	if token, ok := accessTokens.tokens[key]; ok && time.Until(token.Expiry) > time.Minute {
+		return token.Value, true
+	}
+	return "", false
+}
+
+// getAccessToken returns a cached token for key, or calls fetch when the cached
+// token is missing or about to expire.
+func getAccessToken(key string, fetch func() (accessToken, error)) (string, error) {
+	if token, ok := cachedAccessToken(key); ok {
+		return token, nil
+	}
+	accessTokens.Lock()
+	fetching, ok := accessTokens.fetching[key]
+	if !ok {
+		fetching = &sync.Mutex{}
+		accessTokens.fetching[key] = fetching
+	}
+	accessTokens.Unlock()
+
+	fetching.Lock()
+	defer fetching.Unlock()
+	// The token may have been fetched while this call waited
+	if token, ok := cachedAccessToken(key); ok {
+		return token, nil
+	}
+	token, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	accessTokens.Lock()
+	accessTokens.tokens[key] = token
+	accessTokens.Unlock()
+	return token.Value, nil
+}
+
+// requestAccessToken posts an OAuth2 token request and decodes the response.
+func requestAccessToken(ctx context.Context, tokenEndpoint string, form url.Values) (accessToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return accessToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := calendarHTTPClient.Do(req)
+	if err != nil {
+		return accessToken{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return accessToken{}, fmt.Errorf("token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return accessToken{}, err
+	}
+	if token.AccessToken == "" {
+		return accessToken{}, fmt.Errorf("token endpoint returned no access_token")
+	}
+	return accessToken{
+		Value:  token.AccessToken,
+		Expiry: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// getJSON performs an authorized GET request and decodes the JSON response into out.
+func getJSON(ctx context.Context, requestURL string, token string, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := calendarHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s returned %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// parseReplicasTag extracts the desired replicas from a free-text description
+// containing a tag such as "desiredReplicas: 3" or "desiredReplicas=3".
+func parseReplicasTag(description string, tag string) (int, bool) {
+	re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(tag) + `\s*[:=]\s*(\d+)`)
+	match := re.FindStringSubmatch(description)
+	if match == nil {
+		return 0, false
+	}
+	val, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, false
+	}
+	return val, true
+}
+
+// containsTarget reports whether a comma-separated list of scaledobject identifiers contains targetKey.
+func containsTarget(targets string, targetKey string) bool {
+	for _, t := range strings.Split(targets, ",") {
+		if strings.TrimSpace(t) == targetKey {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetAccessToken_FetchesOutsideLock(t *testing.T) {
+	t.Cleanup(func() {
+		accessTokens.Lock()
+		delete(accessTokens.tokens, "test/slow")
+		delete(accessTokens.tokens, "test/fast")
+		accessTokens.Unlock()
+	})
+	var fetches atomic.Int32
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	slow := func() (accessToken, error) {
+		fetches.Add(1)
+		started <- struct{}{}
+		<-release
+		return accessToken{Value: "slow", Expiry: time.Now().Add(time.Hour)}, nil
+	}
+	results := make(chan string, 2)
+	for range 2 {
+		go func() {
+			token, _ := getAccessToken("test/slow", slow)
+			results <- token
+		}()
+	}
+	<-started
+
+	done := make(chan string)
+	go func() {
+		token, _ := getAccessToken("test/fast", func() (accessToken, error) {
+			return accessToken{Value: "fast", Expiry: time.Now().Add(time.Hour)}, nil
+		})
+		done <- token
+	}()
+	select {
+	case token := <-done:
+		if token != "fast" {
+			t.Errorf("expected 'fast', got '%s'", token)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a slow token endpoint not to block other keys")
+	}
+
+	close(release)
+	for range 2 {
+		if token := <-results; token != "slow" {
+			t.Errorf("expected 'slow', got '%s'", token)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected concurrent requests for a key to share one fetch, got %d fetches", n)
+	}
+}
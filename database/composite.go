@@ -0,0 +1,219 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "calendar-scaler/externalscaler"
+)
+
+const (
+	SourcePolicyRequired   = "required"
+	SourcePolicyBestEffort = "bestEffort"
+)
+
+// CompositeSource is one backend of a composite trigger, configured with
+// indexed metadata such as "sources.0.type" and "sources.0.table".
+type CompositeSource struct {
+	Index    int
+	Type     string
+	Policy   string
+	Metadata map[string]string
+}
+
+type CompositeMetadata struct {
+	Sources      []CompositeSource
+	Namespace    string
+	ScaledObject string
+}
+
+// compositeInheritedKeys are copied from the trigger to every source that does not set them.
+var compositeInheritedKeys = []string{"timezone"}
+
+func NewCompositeMetadata(scaledObject *pb.ScaledObjectRef) (*CompositeMetadata, error) {
+	// The trigger itself has no metadata fields besides the sources and the
+	// keys inherited by them
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), &struct{}{}, func(key string) bool {
+		return strings.HasPrefix(key, "sources.") || slices.Contains(compositeInheritedKeys, key)
+	})
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	sources := map[int]*CompositeSource{}
+	for key, value := range scaledObject.GetScalerMetadata() {
+		rest, ok := strings.CutPrefix(key, "sources.")
+		if !ok {
+			continue
+		}
+		indexStr, sourceKey, ok := strings.Cut(rest, ".")
+		index, err := strconv.Atoi(indexStr)
+		if !ok || err != nil || index < 0 || sourceKey == "" {
+			return nil, fmt.Errorf("invalid source key '%s' (expected sources.<index>.<key>)", key)
+		}
+		source, exists := sources[index]
+		if !exists {
+			source = &CompositeSource{Index: index, Metadata: map[string]string{}}
+			sources[index] = source
+		}
+		source.Metadata[sourceKey] = value
+	}
+	meta := &CompositeMetadata{
+		Namespace:    scaledObject.GetNamespace(),
+		ScaledObject: scaledObject.GetName(),
+	}
+	for _, source := range sources {
+		for _, key := range compositeInheritedKeys {
+			if _, ok := source.Metadata[key]; !ok && scaledObject.GetScalerMetadata()[key] != "" {
+				source.Metadata[key] = scaledObject.GetScalerMetadata()[key]
+			}
+		}
+		source.Type = source.Metadata["type"]
+		source.Policy = source.Metadata["policy"]
+		delete(source.Metadata, "policy")
+		meta.Sources = append(meta.Sources, *source)
+	}
+	sort.Slice(meta.Sources, func(i, j int) bool { return meta.Sources[i].Index < meta.Sources[j].Index })
+	if err := meta.validate(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (meta *CompositeMetadata) validate() error {
+	if len(meta.Sources) == 0 {
+		return fmt.Errorf("at least one source (sources.0.type) is required")
+	}
+	for i := range meta.Sources {
+		source := &meta.Sources[i]
+		if source.Type == "" {
+			return fmt.Errorf("sources.%d.type is required", source.Index)
+		}
+		if source.Type == "composite" {
+			return fmt.Errorf("sources.%d.type cannot be composite", source.Index)
+		}
+		switch source.Policy {
+		case "":
+			source.Policy = SourcePolicyRequired
+		case SourcePolicyRequired, SourcePolicyBestEffort:
+		default:
+			return fmt.Errorf("sources.%d.policy must be '%s' or '%s'", source.Index, SourcePolicyRequired, SourcePolicyBestEffort)
+		}
+	}
+	return nil
+}
+
+type compositeMember struct {
+	Source   CompositeSource
+	Database Database
+}
+
+type CompositeDB struct {
+	Meta    *CompositeMetadata
+	members []compositeMember
+}
+
+// NewComposite instantiates every source via NewDatabase. Best-effort sources
+// that cannot be instantiated are skipped, required sources fail the trigger.
+func NewComposite(meta *CompositeMetadata) (*CompositeDB, error) {
+	db := &CompositeDB{Meta: meta}
+	for _, source := range meta.Sources {
+		database, err := NewDatabase(source.Type, &pb.ScaledObjectRef{
+			Name:           meta.ScaledObject,
+			Namespace:      meta.Namespace,
+			ScalerMetadata: source.Metadata,
+		})
+		if err != nil {
+			if source.Policy == SourcePolicyBestEffort {
+				fmt.Printf("[Composite Error] skipping best-effort source %d (%s): %v\n", source.Index, source.Type, err)
+				continue
+			}
+			db.Close()
+			return nil, fmt.Errorf("source %d (%s): %w", source.Index, source.Type, err)
+		}
+		db.members = append(db.members, compositeMember{Source: source, Database: database})
+	}
+	return db, nil
+}
+
+// GetEvents queries all sources concurrently and merges their events.
+func (db *CompositeDB) GetEvents() ([]Event, error) {
+	results := make([][]Event, len(db.members))
+	errs := make([]error, len(db.members))
+	var wg sync.WaitGroup
+	for i, member := range db.members {
+		wg.Add(1)
+		go func(i int, member compositeMember) {
+			defer wg.Done()
+			results[i], errs[i] = member.Database.GetEvents()
+		}(i, member)
+	}
+	wg.Wait()
+
+	var events []Event
+	for i, member := range db.members {
+		if errs[i] != nil {
+			if member.Source.Policy == SourcePolicyBestEffort {
+				fmt.Printf("[Composite Error] ignoring best-effort source %d (%s): %v\n", member.Source.Index, member.Source.Type, errs[i])
+				continue
+			}
+			return nil, fmt.Errorf("source %d (%s): %w", member.Source.Index, member.Source.Type, errs[i])
+		}
+		events = append(events, results[i]...)
+	}
+	return events, nil
+}
+
+// Changes merges the change notifications of all sources that support them.
+func (db *CompositeDB) Changes(ctx context.Context) (<-chan struct{}, error) {
+	var sources []<-chan struct{}
+	for _, member := range db.members {
+		notifier, ok := member.Database.(Notifier)
+		if !ok {
+			continue
+		}
+		changes, err := notifier.Changes(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("source %d (%s): %w", member.Source.Index, member.Source.Type, err)
+		}
+		if changes != nil {
+			sources = append(sources, changes)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	merged := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	for _, changes := range sources {
+		wg.Add(1)
+		go func(changes <-chan struct{}) {
+			defer wg.Done()
+			for range changes {
+				select {
+				case merged <- struct{}{}:
+				default:
+				}
+			}
+		}(changes)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	return merged, nil
+}
+
+func (db *CompositeDB) Close() error {
+	var firstErr error
+	for _, member := range db.members {
+		if err := member.Database.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
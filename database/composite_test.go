@@ -0,0 +1,126 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewCompositeMetadata_Sources(t *testing.T) {
+	meta, err := NewCompositeMetadata(&pb.ScaledObjectRef{
+		ScalerMetadata: map[string]string{
+			"type":             "composite",
+			"timezone":         "Asia/Tokyo",
+			"sources.1.type":   "postgresql",
+			"sources.1.table":  "team_events",
+			"sources.0.type":   "s3",
+			"sources.0.policy": "bestEffort",
+			"sources.0.bucket": "holidays",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(meta.Sources) != 2 || meta.Sources[0].Type != "s3" || meta.Sources[1].Type != "postgresql" {
+		t.Fatalf("unexpected sources %+v", meta.Sources)
+	}
+	if meta.Sources[0].Policy != SourcePolicyBestEffort || meta.Sources[1].Policy != SourcePolicyRequired {
+		t.Errorf("unexpected policies %+v", meta.Sources)
+	}
+	if meta.Sources[1].Metadata["timezone"] != "Asia/Tokyo" {
+		t.Error("expected timezone to be inherited by the sources")
+	}
+	if _, ok := meta.Sources[0].Metadata["policy"]; ok {
+		t.Error("policy should not be passed to the source backend")
+	}
+}
+
+func TestNewCompositeMetadata_Invalid(t *testing.T) {
+	cases := []map[string]string{
+		{},
+		{"sources.x.type": "s3"},
+		{"sources.0.table": "events"},
+		{"sources.0.type": "composite"},
+		{"sources.0.type": "s3", "sources.0.policy": "optional"},
+	}
+	for _, metadata := range cases {
+		if _, err := NewCompositeMetadata(&pb.ScaledObjectRef{ScalerMetadata: metadata}); err == nil {
+			t.Errorf("expected error for %v", metadata)
+		}
+	}
+}
+
+type staticDatabase struct {
+	events []Event
+	err    error
+}
+
+func (s *staticDatabase) GetEvents() ([]Event, error) { return s.events, s.err }
+func (s *staticDatabase) Close() error                { return nil }
+
+func TestCompositeDB_GetEvents(t *testing.T) {
+	holidays := compositeMember{
+		Source:   CompositeSource{Index: 0, Type: "s3", Policy: SourcePolicyRequired},
+		Database: &staticDatabase{events: []Event{{DesiredReplicas: 1}}},
+	}
+	team := compositeMember{
+		Source:   CompositeSource{Index: 1, Type: "postgresql", Policy: SourcePolicyRequired},
+		Database: &staticDatabase{events: []Event{{DesiredReplicas: 4}}},
+	}
+	broken := compositeMember{
+		Source:   CompositeSource{Index: 2, Type: "dynamodb", Policy: SourcePolicyBestEffort},
+		Database: &staticDatabase{err: errors.New("unavailable")},
+	}
+	db := &CompositeDB{Meta: &CompositeMetadata{}, members: []compositeMember{holidays, team, broken}}
+	events, err := db.GetEvents()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("expected events of both sources, got %+v", events)
+	}
+
+	broken.Source.Policy = SourcePolicyRequired
+	db.members[2] = broken
+	if _, err := db.GetEvents(); err == nil {
+		t.Error("expected error when a required source fails")
+	}
+}
+
+type notifyingDatabase struct {
+	staticDatabase
+	changes chan struct{}
+}
+
+func (n *notifyingDatabase) Changes(ctx context.Context) (<-chan struct{}, error) {
+	return n.changes, nil
+}
+
+func TestCompositeDB_Changes(t *testing.T) {
+	source := &notifyingDatabase{changes: make(chan struct{})}
+	db := &CompositeDB{Meta: &CompositeMetadata{}, members: []compositeMember{
+		{Source: CompositeSource{Index: 0, Type: "s3"}, Database: &staticDatabase{}},
+		{Source: CompositeSource{Index: 1, Type: "postgresql"}, Database: source},
+	}}
+	changes, err := db.Changes(context.Background())
+	if err != nil || changes == nil {
+		t.Fatalf("expected merged changes, got %v, %v", changes, err)
+	}
+	source.changes <- struct{}{}
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("expected a change notification")
+	}
+	close(source.changes)
+	if _, ok := <-changes; ok {
+		t.Error("expected merged channel to be closed with its sources")
+	}
+
+	db.members = db.members[:1]
+	if changes, err := db.Changes(context.Background()); err != nil || changes != nil {
+		t.Errorf("expected no changes without notifying sources, got %v, %v", changes, err)
+	}
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Recurring events are stored as a cron expression plus a duration instead of
+// absolute start/end timestamps, e.g. "0 9 * * MON-FRI" for 9h.
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+func parseCron(spec string) (cron.Schedule, error) {
+	schedule, err := cronParser.Parse(strings.TrimSpace(spec))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': %w", spec, err)
+	}
+	return schedule, nil
+}
+
+// cronOccurrence returns the occurrence of schedule that is active at now, if any.
+// The schedule is evaluated in the location of now.
+func cronOccurrence(schedule cron.Schedule, duration time.Duration, now time.Time) (time.Time, time.Time, bool) {
+	start := schedule.Next(now.Add(-duration))
+	if start.IsZero() || start.After(now) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.Add(duration), true
+}
+
+// cronEvent evaluates a cron expression and duration stored in a database row or item.
+func cronEvent(spec string, duration string, desiredReplicas int, now time.Time) (Event, bool, error) {
+	schedule, err := parseCron(spec)
+	if err != nil {
+		return Event{}, false, err
+	}
+	d, err := parseEventDuration(duration)
+	if err != nil {
+		return Event{}, false, err
+	}
+	start, end, ok := cronOccurrence(schedule, d, now)
+	if !ok {
+		return Event{}, false, nil
+	}
+	return Event{StartTime: start, EndTime: end, DesiredReplicas: desiredReplicas}, true, nil
+}
+
+var intervalPattern = regexp.MustCompile(`^(?:(\d+) days? ?)?(?:(\d+):(\d{2}):(\d{2}))?$`)
+
+// parseEventDuration accepts Go durations ("9h", "90m"), plain seconds ("3600")
+// and PostgreSQL interval output ("09:00:00", "1 day 02:00:00").
+func parseEventDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if m := intervalPattern.FindStringSubmatch(value); m != nil && value != "" {
+		var d time.Duration
+		for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+			if m[i+1] != "" {
+				n, _ := strconv.Atoi(m[i+1])
+				d += time.Duration(n) * unit
+			}
+		}
+		if d > 0 {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid duration '%s'", value)
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronEvent(t *testing.T) {
+	location, _ := time.LoadLocation("Asia/Tokyo")
+	// Tuesday 2024-06-11 10:00 in Tokyo
+	now := time.Date(2024, 6, 11, 10, 0, 0, 0, location)
+	event, ok, err := cronEvent("0 9 * * MON-FRI", "9h", 3, now)
+	if err != nil || !ok {
+		t.Fatalf("expected active event, got ok=%v err=%v", ok, err)
+	}
+	if !event.StartTime.Equal(time.Date(2024, 6, 11, 9, 0, 0, 0, location)) || event.DesiredReplicas != 3 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if !event.EndTime.Equal(time.Date(2024, 6, 11, 18, 0, 0, 0, location)) {
+		t.Errorf("unexpected end %s", event.EndTime)
+	}
+	// The same instant is 01:00 UTC, before the UTC window starts
+	if _, ok, _ := cronEvent("0 9 * * MON-FRI", "9h", 3, now.UTC()); ok {
+		t.Error("expected cron to be evaluated in the location of now")
+	}
+	// Saturday
+	if _, ok, _ := cronEvent("0 9 * * MON-FRI", "9h", 3, now.AddDate(0, 0, 4)); ok {
+		t.Error("expected no event on saturday")
+	}
+	if _, _, err := cronEvent("0 9 * *", "9h", 3, now); err == nil {
+		t.Error("expected error for invalid cron expression")
+	}
+}
+
+func TestParseEventDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"9h":             9 * time.Hour,
+		"90m":            90 * time.Minute,
+		"3600":           time.Hour,
+		"09:00:00":       9 * time.Hour,
+		"1 day 02:00:00": 26 * time.Hour,
+		"2 days":         48 * time.Hour,
+	}
+	for value, expected := range cases {
+		d, err := parseEventDuration(value)
+		if err != nil || d != expected {
+			t.Errorf("parseEventDuration(%q) = %s, %v; expected %s", value, d, err, expected)
+		}
+	}
+	for _, value := range []string{"", "0", "-1h", "soon"} {
+		if _, err := parseEventDuration(value); err == nil {
+			t.Errorf("expected error for %q", value)
+		}
+	}
+}
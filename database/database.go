@@ -2,6 +2,7 @@ package database
 
 import (
 	pb "calendar-scaler/externalscaler"
+	"context"
 	"fmt"
 	"time"
 )
@@ -19,21 +20,106 @@ type Database interface {
 	Close() error
 }
 
+// Notifierインターフェース
+// Implemented by backends that can report changes to their events as they happen.
+// Changes returns a channel that receives a value after each change and is closed
+// once ctx is done, or nil if change notifications are not configured.
+type Notifier interface {
+	Changes(ctx context.Context) (<-chan struct{}, error)
+}
+
+// commonMetadata holds the parameters that apply to every trigger type. They are
+// validated when the trigger is created and reported together with the problems
+// of its type.
+type commonMetadata struct {
+	Aggregation string `metadata:"aggregation" default:"max" enum:"max|min|sum"`
+}
+
+func validateCommonMetadata(scaledObject *pb.ScaledObjectRef) metadataProblems {
+	var common commonMetadata
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), &common, func(string) bool {
+		// The keys of the trigger type are checked by the type
+		return true
+	})
+	problems = append(problems, validateMetadata(&common)...)
+	return problems
+}
+
 func NewDatabase(dbType string, metadata *pb.ScaledObjectRef) (Database, error) {
+	problems := validateCommonMetadata(metadata)
 	switch dbType {
 	case "postgresql":
 		metadata, err := NewPostgreSQLMetadata(metadata)
-		if err != nil {
+		if err = problems.with(err); err != nil {
 			return nil, err
 		}
 		return NewPostgresDB(metadata)
 	case "dynamodb":
 		metadata, err := NewDynamoDBMetadata(metadata)
-		if err != nil {
+		if err = problems.with(err); err != nil {
 			return nil, err
 		}
 		return NewDynamoDB(metadata)
+	case "googlecalendar":
+		metadata, err := NewGoogleCalendarMetadata(metadata)
+		if err = problems.with(err); err != nil {
+			return nil, err
+		}
+		return NewGoogleCalendar(metadata)
+	case "msgraph":
+		metadata, err := NewMSGraphMetadata(metadata)
+		if err = problems.with(err); err != nil {
+			return nil, err
+		}
+		return NewMSGraph(metadata)
+	case "s3":
+		metadata, err := NewS3Metadata(metadata)
+		if err = problems.with(err); err != nil {
+			return nil, err
+		}
+		return NewS3(metadata)
+	case "git":
+		metadata, err := NewGitMetadata(metadata)
+		if err = problems.with(err); err != nil {
+			return nil, err
+		}
+		return NewGit(metadata)
+	case "inline":
+		metadata, err := NewInlineMetadata(metadata)
+		if err = problems.with(err); err != nil {
+			return nil, err
+		}
+		return NewInline(metadata)
+	case "composite":
+		metadata, err := NewCompositeMetadata(metadata)
+		if err = problems.with(err); err != nil {
+			return nil, err
+		}
+		return NewComposite(metadata)
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", dbType)
 	}
 }
+
+// AggregateDesiredReplicas combines the desired replicas of concurrently active events.
+// The aggregation is "max" (default), "min" or "sum".
+func AggregateDesiredReplicas(events []Event, aggregation string) (int, error) {
+	result := 0
+	for i, event := range events {
+		switch aggregation {
+		case "", "max":
+			if event.DesiredReplicas > result {
+				result = event.DesiredReplicas
+			}
+		case "min":
+			if i == 0 || event.DesiredReplicas < result {
+				result = event.DesiredReplicas
+			}
+		case "sum":
+			result += event.DesiredReplicas
+		default:
+			return 0, fmt.Errorf("unsupported aggregation: %s", aggregation)
+		}
+	}
+	return result, nil
+}
@@ -2,6 +2,8 @@ package database
 
 import (
 	pb "calendar-scaler/externalscaler"
+	"errors"
+	"slices"
 	"testing"
 )
 
@@ -11,3 +13,28 @@ func TestNewDatabaseUnsupportedType(t *testing.T) {
 		t.Error("Expected error for unsupported database type")
 	}
 }
+
+func TestNewDatabase_ValidatesAggregation(t *testing.T) {
+	_, err := NewDatabase("postgresql", &pb.ScaledObjectRef{ScalerMetadata: map[string]string{"aggregation": "avg"}})
+	var metadataErr *MetadataError
+	if !errors.As(err, &metadataErr) {
+		t.Fatalf("expected a MetadataError, got %v", err)
+	}
+	if !slices.Contains(metadataErr.Problems, "aggregation must be one of max, min, sum") || !slices.Contains(metadataErr.Problems, "username is required") {
+		t.Errorf("expected the aggregation to be reported with the problems of the type, got %v", metadataErr.Problems)
+	}
+}
+
+func TestAggregateDesiredReplicas(t *testing.T) {
+	events := []Event{{DesiredReplicas: 3}, {DesiredReplicas: 1}, {DesiredReplicas: 5}}
+	cases := map[string]int{"": 5, "max": 5, "min": 1, "sum": 9}
+	for aggregation, expected := range cases {
+		got, err := AggregateDesiredReplicas(events, aggregation)
+		if err != nil || got != expected {
+			t.Errorf("aggregation %q: expected %d, got %d (%v)", aggregation, expected, got, err)
+		}
+	}
+	if _, err := AggregateDesiredReplicas(events, "avg"); err == nil {
+		t.Error("expected error for unsupported aggregation")
+	}
+}
@@ -3,216 +3,253 @@ package database
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
 	pb "calendar-scaler/externalscaler"
 )
 
+const (
+	ReplicasParsingStrict  = "strict"
+	ReplicasParsingLenient = "lenient"
+
+	InvalidItemPolicySkip = "skip"
+	InvalidItemPolicyFail = "fail"
+)
+
 type DynamoDBMetadata struct {
-	TableName           string
-	Region              string
-	StartTimeAttr       string
-	EndTimeAttr         string
-	DesiredReplicasAttr string
-	TargetAttr          string
-	TimeZone            string
+	TableName string `metadata:"table,required"`
+	Region    string `metadata:"region,required"`
+	Endpoint  string `metadata:"endpoint"`
+	awsAuth
+	StartTimeAttr       string `metadata:"startAttribute,required"`
+	EndTimeAttr         string `metadata:"endAttribute,required"`
+	DesiredReplicasAttr string `metadata:"desiredReplicasAttribute,required"`
+	TargetAttr          string `metadata:"targetAttribute"`
+	CronAttr            string `metadata:"cronAttribute"`
+	DurationAttr        string `metadata:"durationAttribute"`
+	TimeFormat          string `metadata:"timeFormat" default:"rfc3339"`
+	ReplicasParsing     string `metadata:"replicasParsing" default:"strict" enum:"strict|lenient"`
+	InvalidItemPolicy   string `metadata:"invalidItemPolicy" default:"skip" enum:"skip|fail"`
+	TimeZone            string `metadata:"timezone,required"`
+	Streams             bool   `metadata:"streams"`
+	ConsistentRead      bool   `metadata:"consistentRead"`
+	ScanSegments        int    `metadata:"scanSegments" default:"1"`
 	Namespace           string
 	ScaledObject        string
 }
 
 func NewDynamoDBMetadata(scaledObject *pb.ScaledObjectRef) (*DynamoDBMetadata, error) {
 	meta := &DynamoDBMetadata{
-		TableName:           scaledObject.GetScalerMetadata()["table"],
-		Region:              scaledObject.GetScalerMetadata()["region"],
-		StartTimeAttr:       scaledObject.GetScalerMetadata()["startAttribute"],
-		EndTimeAttr:         scaledObject.GetScalerMetadata()["endAttribute"],
-		DesiredReplicasAttr: scaledObject.GetScalerMetadata()["desiredReplicasAttribute"],
-		TargetAttr:          scaledObject.GetScalerMetadata()["targetAttribute"],
-		TimeZone:            scaledObject.GetScalerMetadata()["timezone"],
-		Namespace:           scaledObject.GetNamespace(),
-		ScaledObject:        scaledObject.GetName(),
-	}
-	if err := meta.validate(); err != nil {
+		Namespace:    scaledObject.GetNamespace(),
+		ScaledObject: scaledObject.GetName(),
+	}
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), meta, nil)
+	// Override endpoint if DYNAMODB_ENDPOINT environment variable is set
+	if meta.Endpoint == "" {
+		meta.Endpoint = os.Getenv("DYNAMODB_ENDPOINT")
+	}
+	problems.add(meta.validate())
+	if err := problems.err(); err != nil {
 		return nil, err
 	}
 	return meta, nil
 }
 
 func (meta *DynamoDBMetadata) validate() error {
-	if meta.TableName == "" {
-		return fmt.Errorf("table name is required")
-	}
-	if meta.StartTimeAttr == "" {
-		return fmt.Errorf("startAttribute is required")
+	problems := validateMetadata(meta)
+	problems.add(meta.awsAuth.validate())
+	if (meta.CronAttr == "") != (meta.DurationAttr == "") {
+		problems.addf("cronAttribute and durationAttribute must be set together")
 	}
-	if meta.EndTimeAttr == "" {
-		return fmt.Errorf("endAttribute is required")
+	problems.add(validateDynamoDBTimeFormat(meta.TimeFormat))
+	if meta.ScanSegments < 1 || meta.ScanSegments > maxDynamoDBScanSegments {
+		problems.addf("scanSegments must be between 1 and %d", maxDynamoDBScanSegments)
 	}
-	if meta.DesiredReplicasAttr == "" {
-		return fmt.Errorf("desiredReplicasAttribute is required")
+	attributes := []struct {
+		param string
+		path  string
+	}{
+		{"startAttribute", meta.StartTimeAttr},
+		{"endAttribute", meta.EndTimeAttr},
+		{"desiredReplicasAttribute", meta.DesiredReplicasAttr},
+		{"targetAttribute", meta.TargetAttr},
+		{"cronAttribute", meta.CronAttr},
+		{"durationAttribute", meta.DurationAttr},
 	}
-	if meta.TimeZone == "" {
-		return fmt.Errorf("timezone is required")
+	for _, attr := range attributes {
+		if attr.path == "" {
+			continue
+		}
+		if _, err := parseAttributePath(attr.path); err != nil {
+			problems.addf("%s: %v", attr.param, err)
+		}
 	}
-	return nil
+	return problems.err()
 }
 
 type DynamoDBClient struct {
 	Client *dynamodb.Client
 	Meta   *DynamoDBMetadata
+
+	cfg    aws.Config
+	awsKey string
 }
 
 func NewDynamoDB(meta *DynamoDBMetadata) (*DynamoDBClient, error) {
-	var cfg aws.Config
-	var err error
-	if meta.Region != "" {
-		cfg, err = config.LoadDefaultConfig(context.TODO(), config.WithRegion(meta.Region))
-	} else {
-		cfg, err = config.LoadDefaultConfig(context.TODO())
-	}
+	settings := awsSettings{Region: meta.Region, awsAuth: meta.awsAuth}
+	cfg, err := loadAWSConfig(context.TODO(), settings)
 	if err != nil {
 		return nil, err
 	}
-
-	// Override endpoint if DYNAMODB_ENDPOINT environment variable is set
-	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
-	var client *dynamodb.Client
-	if endpoint != "" {
-		client = dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
-			o.BaseEndpoint = &endpoint
-		})
-	} else {
-		client = dynamodb.NewFromConfig(cfg)
-	}
-	return &DynamoDBClient{Client: client, Meta: meta}, nil
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if meta.Endpoint != "" {
+			o.BaseEndpoint = &meta.Endpoint
+		}
+	})
+	return &DynamoDBClient{Client: client, Meta: meta, cfg: cfg, awsKey: settings.key()}, nil
 }
 
+// GetEvents scans for the items active now. Target matching is part of the
+// scan filter, so only the items of this scaledobject are returned. With streams
+// the items are read from the in-memory index once it is in sync.
 func (db *DynamoDBClient) GetEvents() ([]Event, error) {
-	if strings.TrimSpace(db.Meta.TargetAttr) == "" {
-		return db.getEventsWithoutTargetAttr()
-	} else {
-		return db.getEventsWithTargetAttr()
-	}
-}
-
-func (db *DynamoDBClient) getEventsWithoutTargetAttr() ([]Event, error) {
 	location, err := time.LoadLocation(db.Meta.TimeZone)
 	if err != nil {
 		fmt.Printf("[DynamoDB Error] failed to load timezone '%s': %v\n", db.Meta.TimeZone, err)
 		return nil, err
 	}
 	now := time.Now().In(location)
-	nowStr := now.Format(time.RFC3339)
-	filter := fmt.Sprintf("%s <= :now AND %s >= :now", db.Meta.StartTimeAttr, db.Meta.EndTimeAttr)
-	exprAttrValues := map[string]types.AttributeValue{
-		":now": &types.AttributeValueMemberS{Value: nowStr},
-	}
-	input := &dynamodb.ScanInput{
-		TableName:                 &db.Meta.TableName,
-		FilterExpression:          &filter,
-		ExpressionAttributeValues: exprAttrValues,
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	result, err := db.Client.Scan(ctx, input)
-	if err != nil {
-		fmt.Printf("[DynamoDB Error] failed to scan table '%s': %v\n", db.Meta.TableName, err)
-		return nil, err
+	items, ok := []map[string]types.AttributeValue(nil), false
+	if db.Meta.Streams {
+		items, ok = db.streamItems()
+	}
+	if !ok {
+		if items, err = db.scan(now); err != nil {
+			return nil, err
+		}
 	}
 	var events []Event
-	for _, item := range result.Items {
-		startStr := getStringAttr(item, db.Meta.StartTimeAttr)
-		endStr := getStringAttr(item, db.Meta.EndTimeAttr)
-		desiredReplicas := getIntAttr(item, db.Meta.DesiredReplicasAttr)
-		start, err := time.Parse(time.RFC3339, startStr)
-		if err != nil {
-			fmt.Printf("[DynamoDB Parse Error] failed to parse startStr '%s': %v\n", startStr, err)
+	for _, item := range items {
+		if db.Meta.TargetAttr != "" && !slices.Contains(getTargetsAttr(item, db.Meta.TargetAttr), db.targetKey()) {
 			continue
 		}
-		end, err := time.Parse(time.RFC3339, endStr)
+		event, ok, err := db.itemEvent(item, now)
 		if err != nil {
-			fmt.Printf("[DynamoDB Parse Error] failed to parse endStr '%s': %v\n", endStr, err)
+			rejectedEvents.WithLabelValues("dynamodb", db.Meta.Namespace, db.Meta.ScaledObject).Inc()
+			if db.Meta.InvalidItemPolicy == InvalidItemPolicyFail {
+				fmt.Printf("[DynamoDB Parse Error] invalid item in table '%s': %v\n", db.Meta.TableName, err)
+				return nil, fmt.Errorf("invalid item in table '%s': %w", db.Meta.TableName, err)
+			}
+			fmt.Printf("[DynamoDB Parse Error] skipping invalid item in table '%s': %v\n", db.Meta.TableName, err)
 			continue
 		}
-		events = append(events, Event{
-			StartTime:       start,
-			EndTime:         end,
-			DesiredReplicas: desiredReplicas,
-		})
+		if ok {
+			events = append(events, event)
+		}
 	}
 	return events, nil
 }
 
-func (db *DynamoDBClient) getEventsWithTargetAttr() ([]Event, error) {
-	location, err := time.LoadLocation(db.Meta.TimeZone)
-	if err != nil {
-		fmt.Printf("[DynamoDB Error] failed to load timezone '%s': %v\n", db.Meta.TimeZone, err)
-		return nil, err
+// hasSchedule reports whether item has start and end attributes or a cron
+// expression. The scan filter only returns such items; other items of the
+// stream index are not events.
+func (db *DynamoDBClient) hasSchedule(item map[string]types.AttributeValue) bool {
+	if db.Meta.CronAttr != "" && getStringAttr(item, db.Meta.CronAttr) != "" {
+		return true
 	}
-	now := time.Now().In(location)
-	nowStr := now.Format(time.RFC3339)
-	filter := fmt.Sprintf("%s <= :now AND %s >= :now", db.Meta.StartTimeAttr, db.Meta.EndTimeAttr)
-	exprAttrValues := map[string]types.AttributeValue{
-		":now": &types.AttributeValueMemberS{Value: nowStr},
-	}
-	input := &dynamodb.ScanInput{
-		TableName:                 &db.Meta.TableName,
-		FilterExpression:          &filter,
-		ExpressionAttributeValues: exprAttrValues,
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	result, err := db.Client.Scan(ctx, input)
-	if err != nil {
-		fmt.Printf("[DynamoDB Error] failed to scan table '%s': %v\n", db.Meta.TableName, err)
-		return nil, err
+	_, hasStart := lookupAttr(item, db.Meta.StartTimeAttr)
+	_, hasEnd := lookupAttr(item, db.Meta.EndTimeAttr)
+	return hasStart && hasEnd
+}
+
+func (db *DynamoDBClient) targetKey() string {
+	return db.Meta.Namespace + "/" + db.Meta.ScaledObject
+}
+
+// scanInput returns the scan of the items that can be active at now.
+func (db *DynamoDBClient) scanInput(now time.Time) *dynamodb.ScanInput {
+	expr := newDynamoExpression()
+	input := &dynamodb.ScanInput{TableName: &db.Meta.TableName}
+	if db.Meta.ConsistentRead {
+		input.ConsistentRead = aws.Bool(true)
 	}
-	var events []Event
-	targetKey := db.Meta.Namespace + "/" + db.Meta.ScaledObject
-	for _, item := range result.Items {
-		startStr := getStringAttr(item, db.Meta.StartTimeAttr)
-		endStr := getStringAttr(item, db.Meta.EndTimeAttr)
-		desiredReplicas := getIntAttr(item, db.Meta.DesiredReplicasAttr)
-		targets := getStringAttr(item, db.Meta.TargetAttr)
-		addEvent := false
-		for _, t := range strings.Split(targets, ",") {
-			if strings.TrimSpace(t) == targetKey {
-				addEvent = true
-				break
-			}
+	filter := db.activeFilter(expr, now)
+	if db.Meta.TargetAttr != "" {
+		// contains matches elements of SS and L attributes and substrings of S
+		// attributes, whose comma-separated targets are checked exactly in Go
+		target := fmt.Sprintf("contains(%s, %s)", expr.name(db.Meta.TargetAttr), expr.value(":target", &types.AttributeValueMemberS{Value: db.targetKey()}))
+		if filter != "" {
+			filter = "(" + filter + ") AND " + target
+		} else {
+			filter = target
 		}
-		if !addEvent {
-			continue
+	}
+	if filter != "" {
+		input.FilterExpression = &filter
+		input.ExpressionAttributeNames = expr.names
+		input.ExpressionAttributeValues = expr.values
+	}
+	return input
+}
+
+// activeFilter matches items that can be active at now; itemEvent makes the exact
+// check. Recurring items are always returned and their cron expression is
+// evaluated in Go.
+func (db *DynamoDBClient) activeFilter(expr *dynamoExpression, now time.Time) string {
+	filter := db.timeFilter(expr, now)
+	if db.Meta.CronAttr == "" || filter == "" {
+		return filter
+	}
+	return fmt.Sprintf("(%s) OR attribute_exists(%s)", filter, expr.name(db.Meta.CronAttr))
+}
+
+// itemEvent converts an item to an event and reports whether it is active at now.
+// Items that cannot be evaluated are reported with an error.
+func (db *DynamoDBClient) itemEvent(item map[string]types.AttributeValue, now time.Time) (Event, bool, error) {
+	var event Event
+	if spec := getStringAttr(item, db.Meta.CronAttr); db.Meta.CronAttr != "" && spec != "" {
+		var ok bool
+		var err error
+		event, ok, err = cronEvent(spec, getDurationAttr(item, db.Meta.DurationAttr), 0, now)
+		if err != nil {
+			return Event{}, false, fmt.Errorf("cronAttribute: %w", err)
 		}
-		start, err := time.Parse(time.RFC3339, startStr)
+		if !ok {
+			return Event{}, false, nil
+		}
+	} else {
+		start, err := db.getTimeAttr(item, db.Meta.StartTimeAttr, now.Location())
 		if err != nil {
-			fmt.Printf("[DynamoDB Parse Error] failed to parse startStr '%s': %v\n", startStr, err)
-			continue
+			return Event{}, false, fmt.Errorf("startAttribute: %w", err)
 		}
-		end, err := time.Parse(time.RFC3339, endStr)
+		end, err := db.getTimeAttr(item, db.Meta.EndTimeAttr, now.Location())
 		if err != nil {
-			fmt.Printf("[DynamoDB Parse Error] failed to parse endStr '%s': %v\n", endStr, err)
-			continue
+			return Event{}, false, fmt.Errorf("endAttribute: %w", err)
 		}
-		events = append(events, Event{
-			StartTime:       start,
-			EndTime:         end,
-			DesiredReplicas: desiredReplicas,
-		})
+		if now.Before(start) || now.After(end) {
+			return Event{}, false, nil
+		}
+		event = Event{StartTime: start, EndTime: end}
 	}
-	return events, nil
+	desiredReplicas, err := getReplicasAttr(item, db.Meta.DesiredReplicasAttr, db.Meta.ReplicasParsing)
+	if err != nil {
+		return Event{}, false, fmt.Errorf("desiredReplicasAttribute: %w", err)
+	}
+	event.DesiredReplicas = desiredReplicas
+	return event, true, nil
 }
 
 func getStringAttr(item map[string]types.AttributeValue, key string) string {
-	if v, ok := item[key]; ok {
+	if v, ok := lookupAttr(item, key); ok {
 		if s, ok := v.(*types.AttributeValueMemberS); ok {
 			return s.Value
 		}
@@ -220,16 +257,78 @@ func getStringAttr(item map[string]types.AttributeValue, key string) string {
 	return ""
 }
 
-func getIntAttr(item map[string]types.AttributeValue, key string) int {
-	if v, ok := item[key]; ok {
-		if n, ok := v.(*types.AttributeValueMemberN); ok {
-			val, err := strconv.Atoi(n.Value)
-			if err == nil {
-				return val
+// getTargetsAttr returns the targets stored as a comma-separated string, a string
+// set or a list of strings.
+func getTargetsAttr(item map[string]types.AttributeValue, key string) []string {
+	v, ok := lookupAttr(item, key)
+	if !ok {
+		return nil
+	}
+	switch a := v.(type) {
+	case *types.AttributeValueMemberS:
+		return splitList(a.Value)
+	case *types.AttributeValueMemberSS:
+		return a.Value
+	case *types.AttributeValueMemberL:
+		var targets []string
+		for _, element := range a.Value {
+			if s, ok := element.(*types.AttributeValueMemberS); ok {
+				targets = append(targets, s.Value)
 			}
 		}
+		return targets
+	}
+	return nil
+}
+
+// getReplicasAttr parses the desired replicas stored as N or S. strict accepts
+// integers only, lenient also accepts surrounding whitespace and decimal or
+// exponent notation and rounds fractions up.
+func getReplicasAttr(item map[string]types.AttributeValue, key string, parsing string) (int, error) {
+	v, ok := lookupAttr(item, key)
+	if !ok {
+		return 0, fmt.Errorf("attribute '%s' is missing", key)
+	}
+	var value string
+	switch a := v.(type) {
+	case *types.AttributeValueMemberN:
+		value = a.Value
+	case *types.AttributeValueMemberS:
+		value = a.Value
+	default:
+		return 0, fmt.Errorf("attribute '%s' must be a number or a string", key)
 	}
-	return 0
+	var replicas int
+	if parsing == ReplicasParsingLenient {
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || math.Ceil(f) > math.MaxInt32 {
+			return 0, fmt.Errorf("'%s' is not a valid number of replicas", value)
+		}
+		replicas = int(math.Ceil(f))
+	} else {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not an integer (use replicasParsing lenient to accept decimals)", value)
+		}
+		replicas = n
+	}
+	if replicas < 0 {
+		return 0, fmt.Errorf("'%s' must not be negative", value)
+	}
+	return replicas, nil
+}
+
+// getDurationAttr returns a duration stored as a string ("9h") or as a number of seconds.
+func getDurationAttr(item map[string]types.AttributeValue, key string) string {
+	if v, ok := lookupAttr(item, key); ok {
+		switch d := v.(type) {
+		case *types.AttributeValueMemberS:
+			return d.Value
+		case *types.AttributeValueMemberN:
+			return d.Value
+		}
+	}
+	return ""
 }
 
 func (db *DynamoDBClient) Close() error {
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Each trigger can use its own static credentials and assume its own role. The
+// AWS config of every unique combination is loaded once and shared, so assumed
+// role credentials are cached and refreshed before they expire instead of
+// calling STS on every request.
+
+const defaultAWSSessionName = "calendar-scaler"
+
+// awsAuth are the credential parameters of the triggers of the AWS backends
+// (DynamoDB and S3), embedded in their metadata.
+type awsAuth struct {
+	RoleArn         string `metadata:"roleArn"`
+	ExternalID      string `metadata:"externalId"`
+	SessionName     string `metadata:"sessionName"`
+	AccessKeyID     string `metadata:"awsAccessKeyId,fromEnv,fromSecret" env:"awsAccessKeyIdEnv"`
+	SecretAccessKey Secret `metadata:"awsSecretAccessKey,fromEnv,fromSecret" env:"awsSecretAccessKeyEnv"`
+}
+
+// awsSettings are the AWS settings of a trigger.
+type awsSettings struct {
+	Region string
+	awsAuth
+}
+
+// awsConfigs holds the loaded config of every unique awsSettings.
+var awsConfigs = struct {
+	sync.Mutex
+	m map[string]aws.Config
+}{m: map[string]aws.Config{}}
+
+func (meta *awsAuth) validate() error {
+	if (meta.AccessKeyID == "") != (meta.SecretAccessKey == "") {
+		return errors.New("awsAccessKeyId and awsSecretAccessKey must be set together")
+	}
+	if meta.RoleArn == "" {
+		if meta.ExternalID != "" || meta.SessionName != "" {
+			return errors.New("externalId and sessionName require roleArn")
+		}
+		return nil
+	}
+	if !strings.HasPrefix(meta.RoleArn, "arn:") || !strings.Contains(meta.RoleArn, ":role/") {
+		return fmt.Errorf("roleArn must be an IAM role ARN")
+	}
+	if meta.SessionName == "" {
+		meta.SessionName = defaultAWSSessionName
+	}
+	return nil
+}
+
+// key identifies the settings without containing the secret access key.
+func (s awsSettings) key() string {
+	return strings.Join([]string{s.Region, s.RoleArn, s.ExternalID, s.SessionName, s.AccessKeyID, s.SecretAccessKey.digest()}, "|")
+}
+
+// loadAWSConfig returns the shared config of s. Without static credentials the
+// default credential chain of the pod is used, and with a role ARN those
+// credentials assume the role.
+func loadAWSConfig(ctx context.Context, s awsSettings) (aws.Config, error) {
+	key := s.key()
+	awsConfigs.Lock()
+	defer awsConfigs.Unlock()
+	if cfg, ok := awsConfigs.m[key]; ok {
+		return cfg, nil
+	}
+	var options []func(*config.LoadOptions) error
+	if s.Region != "" {
+		options = append(options, config.WithRegion(s.Region))
+	}
+	if s.AccessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s.AccessKeyID, s.SecretAccessKey.Value(), "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if s.RoleArn != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), s.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = s.SessionName
+			if s.ExternalID != "" {
+				o.ExternalID = aws.String(s.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	awsConfigs.m[key] = cfg
+	return cfg, nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestAWSAuth_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    awsAuth
+		wantErr bool
+	}{
+		{"default chain", awsAuth{}, false},
+		{"static credentials", awsAuth{AccessKeyID: "AKIA", SecretAccessKey: "secret"}, false},
+		{"access key without secret", awsAuth{AccessKeyID: "AKIA"}, true},
+		{"role", awsAuth{RoleArn: "arn:aws:iam::123456789012:role/calendar", ExternalID: "team-a"}, false},
+		{"external id without role", awsAuth{ExternalID: "team-a"}, true},
+		{"invalid role", awsAuth{RoleArn: "calendar"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.meta.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	meta := awsAuth{RoleArn: "arn:aws:iam::123456789012:role/calendar"}
+	if err := meta.validate(); err != nil || meta.SessionName != defaultAWSSessionName {
+		t.Errorf("expected default session name, got '%s' (err=%v)", meta.SessionName, err)
+	}
+}
+
+func TestNewDynamoDBMetadata_AWSSettings(t *testing.T) {
+	t.Setenv("TEAM_A_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("TEAM_A_SECRET", "")
+	t.Setenv("DYNAMODB_ENDPOINT", "http://localhost:8000")
+	metadata := map[string]string{
+		"table":                    "calendar_events",
+		"region":                   "ap-northeast-1",
+		"startAttribute":           "startEvent",
+		"endAttribute":             "endEvent",
+		"desiredReplicasAttribute": "desiredReplicas",
+		"timezone":                 "Asia/Tokyo",
+		"awsAccessKeyIdEnv":        "TEAM_A_KEY_ID",
+		"awsSecretAccessKeyEnv":    "TEAM_A_SECRET",
+	}
+	if _, err := NewDynamoDBMetadata(&pb.ScaledObjectRef{ScalerMetadata: metadata}); err == nil {
+		t.Error("expected error for empty secret access key variable")
+	}
+
+	t.Setenv("TEAM_A_SECRET", "secret")
+	metadata["endpoint"] = "http://dynamodb.team-a:8000"
+	meta, err := NewDynamoDBMetadata(&pb.ScaledObjectRef{ScalerMetadata: metadata})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.AccessKeyID != "AKIAEXAMPLE" || meta.SecretAccessKey != "secret" {
+		t.Errorf("expected credentials from environment, got '%s'", meta.AccessKeyID)
+	}
+	if meta.Endpoint != "http://dynamodb.team-a:8000" {
+		t.Errorf("expected endpoint to take precedence over DYNAMODB_ENDPOINT, got '%s'", meta.Endpoint)
+	}
+}
+
+func TestLoadAWSConfig_Cache(t *testing.T) {
+	settings := awsSettings{Region: "ap-northeast-1", awsAuth: awsAuth{
+		RoleArn:         "arn:aws:iam::123456789012:role/calendar",
+		SessionName:     defaultAWSSessionName,
+		AccessKeyID:     "AKIAEXAMPLE",
+		SecretAccessKey: "secret",
+	}}
+	if strings.Contains(settings.key(), "secret") {
+		t.Errorf("expected key without secret access key, got '%s'", settings.key())
+	}
+	first, err := loadAWSConfig(context.Background(), settings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := first.Credentials.(*aws.CredentialsCache); !ok {
+		t.Errorf("expected cached assume role credentials, got %T", first.Credentials)
+	}
+	second, _ := loadAWSConfig(context.Background(), settings)
+	if first.Credentials != second.Credentials {
+		t.Error("expected the config to be shared by identical settings")
+	}
+	settings.ExternalID = "team-b"
+	third, _ := loadAWSConfig(context.Background(), settings)
+	if first.Credentials == third.Credentials {
+		t.Error("expected a separate config for a different external ID")
+	}
+}
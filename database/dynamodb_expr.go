@@ -0,0 +1,115 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute names from the trigger metadata are never spliced into expressions.
+// They are parsed as document paths ("window.start", "targets[0]") and every
+// name is replaced by an ExpressionAttributeNames placeholder, so reserved words
+// such as start or end can be used as attribute names.
+
+// attributePathSegment is a map key, optionally followed by list indexes.
+type attributePathSegment struct {
+	Name    string
+	Indexes []int
+}
+
+// parseAttributePath splits a document path into its segments. Dots separate
+// nested map keys and [n] selects a list element.
+func parseAttributePath(path string) ([]attributePathSegment, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, fmt.Errorf("empty attribute path")
+	}
+	var segments []attributePathSegment
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name == "" {
+			return nil, fmt.Errorf("invalid attribute path '%s'", path)
+		}
+		segment := attributePathSegment{Name: name}
+		if rest != "" {
+			for _, index := range strings.Split(strings.TrimSuffix(rest, "]"), "][") {
+				n, err := strconv.Atoi(index)
+				if err != nil || n < 0 || !strings.HasSuffix(rest, "]") {
+					return nil, fmt.Errorf("invalid list index in attribute path '%s'", path)
+				}
+				segment.Indexes = append(segment.Indexes, n)
+			}
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// dynamoExpression collects the attribute name and value placeholders of the
+// expressions of a request.
+type dynamoExpression struct {
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func newDynamoExpression() *dynamoExpression {
+	return &dynamoExpression{names: map[string]string{}, values: map[string]types.AttributeValue{}}
+}
+
+// name returns path with every attribute name replaced by a placeholder. The
+// path must have been validated with parseAttributePath.
+func (e *dynamoExpression) name(path string) string {
+	segments, _ := parseAttributePath(path)
+	parts := make([]string, len(segments))
+	for i, segment := range segments {
+		placeholder := ""
+		for p, name := range e.names {
+			if name == segment.Name {
+				placeholder = p
+				break
+			}
+		}
+		if placeholder == "" {
+			placeholder = "#n" + strconv.Itoa(len(e.names))
+			e.names[placeholder] = segment.Name
+		}
+		parts[i] = placeholder
+		for _, index := range segment.Indexes {
+			parts[i] += "[" + strconv.Itoa(index) + "]"
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// value adds a value placeholder such as ":now".
+func (e *dynamoExpression) value(placeholder string, value types.AttributeValue) string {
+	e.values[placeholder] = value
+	return placeholder
+}
+
+// lookupAttr returns the attribute at path in item.
+func lookupAttr(item map[string]types.AttributeValue, path string) (types.AttributeValue, bool) {
+	segments, err := parseAttributePath(path)
+	if err != nil {
+		return nil, false
+	}
+	var current types.AttributeValue = &types.AttributeValueMemberM{Value: item}
+	for _, segment := range segments {
+		m, ok := current.(*types.AttributeValueMemberM)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m.Value[segment.Name]; !ok {
+			return nil, false
+		}
+		for _, index := range segment.Indexes {
+			l, ok := current.(*types.AttributeValueMemberL)
+			if !ok || index >= len(l.Value) {
+				return nil, false
+			}
+			current = l.Value[index]
+		}
+	}
+	return current, true
+}
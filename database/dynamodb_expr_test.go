@@ -0,0 +1,80 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestParseAttributePath(t *testing.T) {
+	tests := map[string][]attributePathSegment{
+		"start":               {{Name: "start"}},
+		"window.start":        {{Name: "window"}, {Name: "start"}},
+		"windows[1].end":      {{Name: "windows", Indexes: []int{1}}, {Name: "end"}},
+		"matrix[0][2]":        {{Name: "matrix", Indexes: []int{0, 2}}},
+		"with-dash.and space": {{Name: "with-dash"}, {Name: "and space"}},
+	}
+	for path, expected := range tests {
+		got, err := parseAttributePath(path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %+v, got %+v", path, expected, got)
+		}
+	}
+	for _, path := range []string{"", "window..start", ".start", "list[x]", "list[1", "list[-1]"} {
+		if _, err := parseAttributePath(path); err == nil {
+			t.Errorf("%q: expected error", path)
+		}
+	}
+}
+
+func TestDynamoDBClient_ScanInputUsesPlaceholders(t *testing.T) {
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{
+		TableName:           "calendar_events",
+		StartTimeAttr:       "window.start",
+		EndTimeAttr:         "window.end",
+		DesiredReplicasAttr: "size",
+		CronAttr:            "schedule",
+		DurationAttr:        "duration",
+	}}
+	input := db.scanInput(time.Date(2024, 6, 11, 12, 0, 0, 0, time.UTC))
+	expected := "(#n0.#n1 <= :latestStart AND #n0.#n2 >= :earliestEnd) OR attribute_exists(#n3)"
+	if *input.FilterExpression != expected {
+		t.Errorf("unexpected filter\n got: %s\nwant: %s", *input.FilterExpression, expected)
+	}
+	names := map[string]string{"#n0": "window", "#n1": "start", "#n2": "end", "#n3": "schedule"}
+	if !reflect.DeepEqual(input.ExpressionAttributeNames, names) {
+		t.Errorf("unexpected attribute names %v", input.ExpressionAttributeNames)
+	}
+	if start, ok := input.ExpressionAttributeValues[":latestStart"].(*types.AttributeValueMemberS); !ok || start.Value != "2024-06-12T02:01:00Z" {
+		t.Errorf("unexpected :latestStart value %v", input.ExpressionAttributeValues[":latestStart"])
+	}
+}
+
+func TestLookupAttr(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"window": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"start": &types.AttributeValueMemberS{Value: "2024-06-11T09:00:00+09:00"},
+		}},
+		"sizes": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+			&types.AttributeValueMemberN{Value: "2"},
+			&types.AttributeValueMemberN{Value: "5"},
+		}},
+	}
+	if got := getStringAttr(item, "window.start"); got != "2024-06-11T09:00:00+09:00" {
+		t.Errorf("unexpected nested value %q", got)
+	}
+	if got, err := getReplicasAttr(item, "sizes[1]", ReplicasParsingStrict); err != nil || got != 5 {
+		t.Errorf("unexpected list value %d", got)
+	}
+	for _, path := range []string{"window.end", "sizes[2]", "window[0]", "sizes.start"} {
+		if _, ok := lookupAttr(item, path); ok {
+			t.Errorf("%s: expected no value", path)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Large tables are scanned in scanSegments parallel segments, so that reading
+// all pages stays within the deadline of a KEDA request. At most
+// dynamoDBScanConcurrency segments are scanned at the same time. The stream
+// index is loaded the same way, with a deadline per page instead.
+
+const (
+	maxDynamoDBScanSegments = 1000
+	dynamoDBScanConcurrency = 16
+	dynamoDBScanTimeout     = 3 * time.Second
+)
+
+// scan returns the items that can be active at now, reading every page of every
+// segment. Items are merged in segment order.
+func (db *DynamoDBClient) scan(now time.Time) ([]map[string]types.AttributeValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBScanTimeout)
+	defer cancel()
+	items, err := scanSegments(ctx, db.Client, db.Meta.ScanSegments, 0, func() *dynamodb.ScanInput {
+		return db.scanInput(now)
+	})
+	if err != nil {
+		fmt.Printf("[DynamoDB Error] failed to scan table '%s': %v\n", db.Meta.TableName, err)
+		return nil, err
+	}
+	return items, nil
+}
+
+// scanSegments runs the input returned by input in parallel segments and merges
+// their items in segment order. With a pageTimeout each page has its own
+// deadline, otherwise the pages share the deadline of ctx.
+func scanSegments(ctx context.Context, client *dynamodb.Client, segments int, pageTimeout time.Duration, input func() *dynamodb.ScanInput) ([]map[string]types.AttributeValue, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	segments = max(segments, 1)
+	results := make([][]map[string]types.AttributeValue, segments)
+	errs := make([]error, segments)
+	workers := make(chan struct{}, dynamoDBScanConcurrency)
+	var wg sync.WaitGroup
+	for segment := range segments {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			input := input()
+			if segments > 1 {
+				input.Segment = aws.Int32(int32(segment))
+				input.TotalSegments = aws.Int32(int32(segments))
+			}
+			results[segment], errs[segment] = scanPages(ctx, client, input, pageTimeout)
+			if errs[segment] != nil {
+				// The other segments cannot make the result complete
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+	}
+	var items []map[string]types.AttributeValue
+	for _, result := range results {
+		items = append(items, result...)
+	}
+	return items, nil
+}
+
+// scanPages runs input until the last page.
+func scanPages(ctx context.Context, client *dynamodb.Client, input *dynamodb.ScanInput, pageTimeout time.Duration) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	paginator := dynamodb.NewScanPaginator(client, input)
+	for paginator.HasMorePages() {
+		pageCtx, cancel := ctx, context.CancelFunc(func() {})
+		if pageTimeout > 0 {
+			pageCtx, cancel = context.WithTimeout(ctx, pageTimeout)
+		}
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+	}
+	return items, nil
+}
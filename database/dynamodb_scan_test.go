@@ -0,0 +1,142 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// fakeDynamoDBScan serves two pages per segment, each with one active item,
+// after delay.
+func fakeDynamoDBScan(t *testing.T, requests *[]map[string]any, delay time.Duration) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		var input map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		mu.Lock()
+		*requests = append(*requests, input)
+		mu.Unlock()
+		segment, _ := input["Segment"].(float64)
+		page := 1
+		if input["ExclusiveStartKey"] != nil {
+			page = 2
+		}
+		name := fmt.Sprintf("segment%d-page%d", int(segment), page)
+		item := map[string]any{
+			"EventName":       map[string]string{"S": name},
+			"startEvent":      map[string]string{"S": "2000-01-01T00:00:00Z"},
+			"endEvent":        map[string]string{"S": "2100-01-01T00:00:00Z"},
+			"desiredReplicas": map[string]string{"N": "2"},
+		}
+		output := map[string]any{"Items": []any{item}}
+		if page == 1 {
+			output["LastEvaluatedKey"] = map[string]any{"EventName": map[string]string{"S": name}}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(output)
+	}))
+}
+
+func TestDynamoDBClient_ScanSegments(t *testing.T) {
+	var requests []map[string]any
+	server := fakeDynamoDBScan(t, &requests, 0)
+	defer server.Close()
+
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("AKIAEXAMPLE", "secret", ""),
+	})
+	db := &DynamoDBClient{Client: client, Meta: &DynamoDBMetadata{
+		TableName:           "calendar_events",
+		StartTimeAttr:       "startEvent",
+		EndTimeAttr:         "endEvent",
+		DesiredReplicasAttr: "desiredReplicas",
+		TimeZone:            "UTC",
+		ConsistentRead:      true,
+		ScanSegments:        3,
+	}}
+	events, err := db.GetEvents()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 6 {
+		t.Errorf("expected 6 events from 3 segments with 2 pages each, got %d", len(events))
+	}
+	if len(requests) != 6 {
+		t.Fatalf("expected 6 scan requests, got %d", len(requests))
+	}
+	for _, input := range requests {
+		if input["ConsistentRead"] != true || input["TotalSegments"] != float64(3) {
+			t.Errorf("expected consistent segmented scan, got %v", input)
+		}
+	}
+}
+
+func TestDynamoStream_ScanPageDeadlines(t *testing.T) {
+	var requests []map[string]any
+	server := fakeDynamoDBScan(t, &requests, 150*time.Millisecond)
+	defer server.Close()
+
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		Credentials:      credentials.NewStaticCredentialsProvider("AKIAEXAMPLE", "secret", ""),
+		RetryMaxAttempts: 1,
+	})
+	// Both pages of a segment take longer than one page deadline
+	items, err := scanSegments(context.Background(), client, 3, 250*time.Millisecond, func() *dynamodb.ScanInput {
+		return &dynamodb.ScanInput{TableName: aws.String("calendar_events"), ConsistentRead: aws.Bool(true)}
+	})
+	if err != nil {
+		t.Fatalf("expected every page to have its own deadline, got %v", err)
+	}
+	if len(items) != 6 {
+		t.Errorf("expected 6 items from 3 segments with 2 pages each, got %d", len(items))
+	}
+	for _, input := range requests {
+		if input["ConsistentRead"] != true || input["TotalSegments"] != float64(3) {
+			t.Errorf("expected consistent segmented scan, got %v", input)
+		}
+	}
+
+	s := &dynamoStream{table: "calendar_events", segments: 3, client: client}
+	indexed, err := s.scan([]string{"EventName"})
+	if err != nil || len(indexed) != 6 {
+		t.Errorf("expected the stream index to hold 6 items, got %d (err=%v)", len(indexed), err)
+	}
+}
+
+func TestNewDynamoDBMetadata_ScanOptions(t *testing.T) {
+	metadata := map[string]string{
+		"table":                    "calendar_events",
+		"region":                   "ap-northeast-1",
+		"startAttribute":           "startEvent",
+		"endAttribute":             "endEvent",
+		"desiredReplicasAttribute": "desiredReplicas",
+		"timezone":                 "Asia/Tokyo",
+	}
+	meta, err := NewDynamoDBMetadata(&pb.ScaledObjectRef{ScalerMetadata: metadata})
+	if err != nil || meta.ConsistentRead || meta.ScanSegments != 1 {
+		t.Errorf("expected eventually consistent single segment scan by default, got %+v (err=%v)", meta, err)
+	}
+	for _, segments := range []string{"0", "1001", "four"} {
+		metadata["scanSegments"] = segments
+		if _, err := NewDynamoDBMetadata(&pb.ScaledObjectRef{ScalerMetadata: metadata}); err == nil {
+			t.Errorf("expected error for scanSegments '%s'", segments)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// With streams enabled the items of the table are kept in memory and updated from
+// its DynamoDB stream, so GetEvents does not scan the table and changes are
+// pushed to StreamIsActive as soon as their records are read. The table needs a
+// stream with NEW_IMAGE or NEW_AND_OLD_IMAGES.
+
+const (
+	dynamoStreamPollInterval     = time.Second
+	dynamoStreamDiscoverInterval = 30 * time.Second
+	dynamoStreamRetryInterval    = 5 * time.Second
+	dynamoStreamRequestTimeout   = 10 * time.Second
+	// dynamoStreamIdleTimeout stops a stream that has no subscribers and has not
+	// been read by GetEvents, e.g. after its ScaledObject was deleted.
+	dynamoStreamIdleTimeout = 10 * time.Minute
+)
+
+var errDynamoStreamIdle = errors.New("stream is idle")
+
+// dynamoStream is the in-memory index of one table, shared by all triggers that
+// read it. Its fields are guarded by dynamoStreams.
+type dynamoStream struct {
+	key      string
+	table    string
+	segments int
+	client   *dynamodb.Client
+	streams  *dynamodbstreams.Client
+
+	items       map[string]map[string]types.AttributeValue
+	ready       bool
+	lastRead    time.Time
+	subscribers map[chan struct{}]struct{}
+}
+
+// dynamoStreams holds one stream reader per endpoint, AWS settings and table.
+var dynamoStreams = struct {
+	sync.Mutex
+	m map[string]*dynamoStream
+}{m: map[string]*dynamoStream{}}
+
+// stream returns the reader of the table, starting it if necessary.
+func (db *DynamoDBClient) stream() *dynamoStream {
+	key := db.Meta.Endpoint + "|" + db.awsKey + "|" + db.Meta.TableName
+	dynamoStreams.Lock()
+	defer dynamoStreams.Unlock()
+	s, ok := dynamoStreams.m[key]
+	if !ok {
+		endpoint := db.Meta.Endpoint
+		s = &dynamoStream{
+			key:      key,
+			table:    db.Meta.TableName,
+			segments: db.Meta.ScanSegments,
+			client:   db.Client,
+			streams: dynamodbstreams.NewFromConfig(db.cfg, func(o *dynamodbstreams.Options) {
+				if endpoint != "" {
+					o.BaseEndpoint = &endpoint
+				}
+			}),
+			subscribers: map[chan struct{}]struct{}{},
+		}
+		dynamoStreams.m[key] = s
+		go s.run()
+	}
+	s.lastRead = time.Now()
+	return s
+}
+
+// streamItems returns the items of the table that have a schedule, or false
+// while the index is not (yet) in sync with the stream.
+func (db *DynamoDBClient) streamItems() ([]map[string]types.AttributeValue, bool) {
+	s := db.stream()
+	dynamoStreams.Lock()
+	defer dynamoStreams.Unlock()
+	if !s.ready {
+		return nil, false
+	}
+	var items []map[string]types.AttributeValue
+	for _, item := range s.items {
+		if db.hasSchedule(item) {
+			items = append(items, item)
+		}
+	}
+	return items, true
+}
+
+// Changes subscribes to the stream of the table. A notification is also sent
+// after the index has been (re)loaded.
+func (db *DynamoDBClient) Changes(ctx context.Context) (<-chan struct{}, error) {
+	if !db.Meta.Streams {
+		return nil, nil
+	}
+	s := db.stream()
+	ch := make(chan struct{}, 1)
+	dynamoStreams.Lock()
+	s.subscribers[ch] = struct{}{}
+	dynamoStreams.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		dynamoStreams.Lock()
+		defer dynamoStreams.Unlock()
+		delete(s.subscribers, ch)
+		close(ch)
+		s.lastRead = time.Now()
+	}()
+	return ch, nil
+}
+
+// run follows the stream until it is idle. After an error, e.g. an expired
+// iterator or trimmed records, the index is reloaded from scratch.
+func (s *dynamoStream) run() {
+	for {
+		err := s.follow()
+		dynamoStreams.Lock()
+		s.ready = false
+		s.items = nil
+		idle := s.idle()
+		if idle {
+			delete(dynamoStreams.m, s.key)
+		}
+		dynamoStreams.Unlock()
+		if idle {
+			return
+		}
+		fmt.Printf("[DynamoDB Error] stream of table '%s': %v\n", s.table, err)
+		time.Sleep(dynamoStreamRetryInterval)
+	}
+}
+
+// idle must be called with dynamoStreams locked.
+func (s *dynamoStream) idle() bool {
+	return len(s.subscribers) == 0 && time.Since(s.lastRead) > dynamoStreamIdleTimeout
+}
+
+// notify must be called with dynamoStreams locked.
+func (s *dynamoStream) notify() {
+	for ch := range s.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// follow loads the table and applies the records of its stream. Iterators of the
+// open shards are taken before the scan, so no change is missed; records of
+// changes the scan already saw are applied again, which is harmless because
+// they carry the complete new item.
+func (s *dynamoStream) follow() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoStreamRequestTimeout)
+	table, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &s.table})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to describe table: %w", err)
+	}
+	spec := table.Table.StreamSpecification
+	if table.Table.LatestStreamArn == nil || spec == nil || spec.StreamEnabled == nil || !*spec.StreamEnabled {
+		return errors.New("streams are not enabled on the table")
+	}
+	if spec.StreamViewType != types.StreamViewTypeNewImage && spec.StreamViewType != types.StreamViewTypeNewAndOldImages {
+		return fmt.Errorf("stream view type must be NEW_IMAGE or NEW_AND_OLD_IMAGES, not %s", spec.StreamViewType)
+	}
+	streamArn := *table.Table.LatestStreamArn
+	var keyNames []string
+	for _, key := range table.Table.KeySchema {
+		keyNames = append(keyNames, *key.AttributeName)
+	}
+
+	shards, err := s.listShards(streamArn)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	iterators := map[string]*string{}
+	for _, shard := range shards {
+		known[*shard.ShardId] = true
+		if shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+			continue
+		}
+		if iterators[*shard.ShardId], err = s.shardIterator(streamArn, *shard.ShardId, streamtypes.ShardIteratorTypeLatest); err != nil {
+			return err
+		}
+	}
+
+	items, err := s.scan(keyNames)
+	if err != nil {
+		return err
+	}
+	dynamoStreams.Lock()
+	s.items = items
+	s.ready = true
+	s.notify()
+	dynamoStreams.Unlock()
+
+	lastDiscover := time.Now()
+	for {
+		discover := time.Since(lastDiscover) > dynamoStreamDiscoverInterval
+		for shardID, iterator := range iterators {
+			ctx, cancel := context.WithTimeout(context.Background(), dynamoStreamRequestTimeout)
+			result, err := s.streams.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: iterator})
+			cancel()
+			if err != nil {
+				return fmt.Errorf("failed to get records of shard %s: %w", shardID, err)
+			}
+			if len(result.Records) > 0 {
+				dynamoStreams.Lock()
+				applyStreamRecords(s.items, result.Records, keyNames)
+				s.notify()
+				dynamoStreams.Unlock()
+			}
+			if result.NextShardIterator == nil {
+				// The shard was closed, its children continue where it ended
+				delete(iterators, shardID)
+				discover = true
+			} else {
+				iterators[shardID] = result.NextShardIterator
+			}
+		}
+
+		if discover {
+			shards, err := s.listShards(streamArn)
+			if err != nil {
+				return err
+			}
+			for _, shard := range shards {
+				if known[*shard.ShardId] {
+					continue
+				}
+				// Children are read once their parent is drained, so the
+				// records of an item are applied in order
+				if shard.ParentShardId != nil && iterators[*shard.ParentShardId] != nil {
+					continue
+				}
+				known[*shard.ShardId] = true
+				if iterators[*shard.ShardId], err = s.shardIterator(streamArn, *shard.ShardId, streamtypes.ShardIteratorTypeTrimHorizon); err != nil {
+					return err
+				}
+			}
+			lastDiscover = time.Now()
+		}
+
+		dynamoStreams.Lock()
+		idle := s.idle()
+		dynamoStreams.Unlock()
+		if idle {
+			return errDynamoStreamIdle
+		}
+		time.Sleep(dynamoStreamPollInterval)
+	}
+}
+
+func (s *dynamoStream) listShards(streamArn string) ([]streamtypes.Shard, error) {
+	var shards []streamtypes.Shard
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: &streamArn}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), dynamoStreamRequestTimeout)
+		result, err := s.streams.DescribeStream(ctx, input)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe stream: %w", err)
+		}
+		shards = append(shards, result.StreamDescription.Shards...)
+		if result.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		input.ExclusiveStartShardId = result.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+func (s *dynamoStream) shardIterator(streamArn string, shardID string, iteratorType streamtypes.ShardIteratorType) (*string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoStreamRequestTimeout)
+	defer cancel()
+	result, err := s.streams.GetShardIterator(ctx, &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         &streamArn,
+		ShardId:           &shardID,
+		ShardIteratorType: iteratorType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get iterator of shard %s: %w", shardID, err)
+	}
+	return result.ShardIterator, nil
+}
+
+// scan reads all items of the table. A large table takes longer than any single
+// request deadline, so each page has its own.
+func (s *dynamoStream) scan(keyNames []string) (map[string]map[string]types.AttributeValue, error) {
+	items := map[string]map[string]types.AttributeValue{}
+	scanned, err := scanSegments(context.Background(), s.client, s.segments, dynamoStreamRequestTimeout, func() *dynamodb.ScanInput {
+		// An eventually consistent read could miss writes made just before the
+		// iterators were taken, whose records are not read again
+		return &dynamodb.ScanInput{TableName: &s.table, ConsistentRead: aws.Bool(true)}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan table: %w", err)
+	}
+	for _, item := range scanned {
+		items[dynamoItemKey(item, keyNames)] = item
+	}
+	return items, nil
+}
+
+// applyStreamRecords updates items with the new images of inserted and modified
+// items and removes deleted items.
+func applyStreamRecords(items map[string]map[string]types.AttributeValue, records []streamtypes.Record, keyNames []string) {
+	for _, record := range records {
+		if record.Dynamodb == nil {
+			continue
+		}
+		key := dynamoItemKey(fromStreamItem(record.Dynamodb.Keys), keyNames)
+		switch record.EventName {
+		case streamtypes.OperationTypeInsert, streamtypes.OperationTypeModify:
+			items[key] = fromStreamItem(record.Dynamodb.NewImage)
+		case streamtypes.OperationTypeRemove:
+			delete(items, key)
+		}
+	}
+}
+
+// dynamoItemKey identifies an item by its primary key attributes.
+func dynamoItemKey(item map[string]types.AttributeValue, keyNames []string) string {
+	parts := make([]string, len(keyNames))
+	for i, name := range keyNames {
+		switch v := item[name].(type) {
+		case *types.AttributeValueMemberS:
+			parts[i] = "S:" + v.Value
+		case *types.AttributeValueMemberN:
+			parts[i] = "N:" + v.Value
+		case *types.AttributeValueMemberB:
+			parts[i] = "B:" + base64.StdEncoding.EncodeToString(v.Value)
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func fromStreamItem(item map[string]streamtypes.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	converted := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		converted[name] = fromStreamAttributeValue(value)
+	}
+	return converted
+}
+
+// fromStreamAttributeValue converts an attribute value of a stream record to the
+// type used by the DynamoDB client.
+func fromStreamAttributeValue(value streamtypes.AttributeValue) types.AttributeValue {
+	switch v := value.(type) {
+	case *streamtypes.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *streamtypes.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBS:
+		return &types.AttributeValueMemberBS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberL:
+		list := make([]types.AttributeValue, len(v.Value))
+		for i, element := range v.Value {
+			list[i] = fromStreamAttributeValue(element)
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case *streamtypes.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: fromStreamItem(v.Value)}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+func TestFromStreamAttributeValue(t *testing.T) {
+	item := fromStreamItem(map[string]streamtypes.AttributeValue{
+		"EventName": &streamtypes.AttributeValueMemberS{Value: "release"},
+		"window": &streamtypes.AttributeValueMemberM{Value: map[string]streamtypes.AttributeValue{
+			"start": &streamtypes.AttributeValueMemberS{Value: "2024-06-11T09:00:00+09:00"},
+		}},
+		"targets":         &streamtypes.AttributeValueMemberL{Value: []streamtypes.AttributeValue{&streamtypes.AttributeValueMemberS{Value: "default/app"}}},
+		"desiredReplicas": &streamtypes.AttributeValueMemberN{Value: "3"},
+	})
+	if got := getStringAttr(item, "window.start"); got != "2024-06-11T09:00:00+09:00" {
+		t.Errorf("expected nested start, got '%s'", got)
+	}
+	if got := getTargetsAttr(item, "targets"); len(got) != 1 || got[0] != "default/app" {
+		t.Errorf("expected list target, got %v", got)
+	}
+	if replicas, err := getReplicasAttr(item, "desiredReplicas", ReplicasParsingStrict); err != nil || replicas != 3 {
+		t.Errorf("expected 3 replicas, got %d (err=%v)", replicas, err)
+	}
+}
+
+func TestApplyStreamRecords(t *testing.T) {
+	keyNames := []string{"EventName"}
+	record := func(operation streamtypes.OperationType, name string, replicas string) streamtypes.Record {
+		keys := map[string]streamtypes.AttributeValue{"EventName": &streamtypes.AttributeValueMemberS{Value: name}}
+		r := streamtypes.Record{EventName: operation, Dynamodb: &streamtypes.StreamRecord{Keys: keys}}
+		if replicas != "" {
+			r.Dynamodb.NewImage = map[string]streamtypes.AttributeValue{
+				"EventName":       keys["EventName"],
+				"desiredReplicas": &streamtypes.AttributeValueMemberN{Value: replicas},
+			}
+		}
+		return r
+	}
+	items := map[string]map[string]types.AttributeValue{}
+	applyStreamRecords(items, []streamtypes.Record{
+		record(streamtypes.OperationTypeInsert, "a", "1"),
+		record(streamtypes.OperationTypeInsert, "b", "2"),
+		record(streamtypes.OperationTypeModify, "a", "5"),
+		record(streamtypes.OperationTypeRemove, "b", ""),
+	}, keyNames)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(items))
+	}
+	item := items[dynamoItemKey(map[string]types.AttributeValue{"EventName": &types.AttributeValueMemberS{Value: "a"}}, keyNames)]
+	if replicas, _ := getReplicasAttr(item, "desiredReplicas", ReplicasParsingStrict); replicas != 5 {
+		t.Errorf("expected modified item with 5 replicas, got %v", item)
+	}
+}
+
+func TestDynamoItemKey(t *testing.T) {
+	keyNames := []string{"pk", "sk"}
+	a := dynamoItemKey(map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: "a"},
+		"sk": &types.AttributeValueMemberN{Value: "1"},
+	}, keyNames)
+	b := dynamoItemKey(map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: "a"},
+		"sk": &types.AttributeValueMemberS{Value: "1"},
+	}, keyNames)
+	if a == b {
+		t.Errorf("expected keys of different types to differ, got '%s'", a)
+	}
+}
+
+func TestDynamoDBClient_HasSchedule(t *testing.T) {
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{StartTimeAttr: "start", EndTimeAttr: "end", CronAttr: "cron"}}
+	tests := []struct {
+		item map[string]types.AttributeValue
+		want bool
+	}{
+		{map[string]types.AttributeValue{"start": &types.AttributeValueMemberS{}, "end": &types.AttributeValueMemberS{}}, true},
+		{map[string]types.AttributeValue{"cron": &types.AttributeValueMemberS{Value: "0 9 * * *"}}, true},
+		{map[string]types.AttributeValue{"start": &types.AttributeValueMemberS{}}, false},
+		{map[string]types.AttributeValue{"EventName": &types.AttributeValueMemberS{Value: "config"}}, false},
+	}
+	for _, tt := range tests {
+		if got := db.hasSchedule(tt.item); got != tt.want {
+			t.Errorf("hasSchedule(%v) = %v, want %v", tt.item, got, tt.want)
+		}
+	}
+}
+
+func TestNewDynamoDBMetadata_Streams(t *testing.T) {
+	metadata := map[string]string{
+		"table":                    "calendar_events",
+		"region":                   "ap-northeast-1",
+		"startAttribute":           "startEvent",
+		"endAttribute":             "endEvent",
+		"desiredReplicasAttribute": "desiredReplicas",
+		"timezone":                 "Asia/Tokyo",
+		"streams":                  "true",
+	}
+	meta, err := NewDynamoDBMetadata(&pb.ScaledObjectRef{ScalerMetadata: metadata})
+	if err != nil || !meta.Streams {
+		t.Errorf("expected streams to be enabled, got %+v (err=%v)", meta, err)
+	}
+	metadata["streams"] = "yes"
+	if _, err := NewDynamoDBMetadata(&pb.ScaledObjectRef{ScalerMetadata: metadata}); err == nil {
+		t.Error("expected error for invalid streams value")
+	}
+}
@@ -2,7 +2,12 @@ package database
 
 import (
 	pb "calendar-scaler/externalscaler"
+	"errors"
+	"slices"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 func TestNewDynamoDBMetadata_RequiredFields(t *testing.T) {
@@ -38,3 +43,129 @@ func TestDynamoDBMetadata_Validate(t *testing.T) {
 		t.Error("expected error for missing required fields")
 	}
 }
+
+func TestDynamoDBClient_ItemEventCron(t *testing.T) {
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{
+		StartTimeAttr:       "startEvent",
+		EndTimeAttr:         "endEvent",
+		DesiredReplicasAttr: "desiredReplicas",
+		CronAttr:            "cron",
+		DurationAttr:        "duration",
+	}}
+	item := map[string]types.AttributeValue{
+		"cron":            &types.AttributeValueMemberS{Value: "0 9 * * MON-FRI"},
+		"duration":        &types.AttributeValueMemberN{Value: "32400"},
+		"desiredReplicas": &types.AttributeValueMemberN{Value: "2"},
+	}
+	location, _ := time.LoadLocation("Asia/Tokyo")
+	event, ok, err := db.itemEvent(item, time.Date(2024, 6, 11, 17, 59, 0, 0, location))
+	if err != nil || !ok || event.DesiredReplicas != 2 {
+		t.Errorf("expected active recurring event, got %+v (ok=%v, err=%v)", event, ok, err)
+	}
+	if _, ok, _ := db.itemEvent(item, time.Date(2024, 6, 11, 18, 1, 0, 0, location)); ok {
+		t.Error("expected recurring event to be inactive after its duration")
+	}
+}
+
+func TestDynamoDBClient_TargetFilter(t *testing.T) {
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{
+		StartTimeAttr: "start", EndTimeAttr: "end", TargetAttr: "targets", TimeFormat: "epochSeconds",
+		Namespace: "default", ScaledObject: "app",
+	}}
+	input := db.scanInput(time.Unix(1718074800, 0))
+	if *input.FilterExpression != "((attribute_type(#n0, :string) OR #n0 <= :now) AND (attribute_type(#n1, :string) OR #n1 >= :now)) AND contains(#n2, :target)" {
+		t.Errorf("unexpected filter %s", *input.FilterExpression)
+	}
+	if target, ok := input.ExpressionAttributeValues[":target"].(*types.AttributeValueMemberS); !ok || target.Value != "default/app" {
+		t.Errorf("unexpected :target value %v", input.ExpressionAttributeValues[":target"])
+	}
+
+	items := []struct {
+		value types.AttributeValue
+		match bool
+	}{
+		{&types.AttributeValueMemberS{Value: "default/app, default/web"}, true},
+		{&types.AttributeValueMemberS{Value: "default/app-canary"}, false},
+		{&types.AttributeValueMemberSS{Value: []string{"default/web", "default/app"}}, true},
+		{&types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "default/app"}}}, true},
+		{&types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "default/app,default/web"}}}, false},
+	}
+	for _, tt := range items {
+		targets := getTargetsAttr(map[string]types.AttributeValue{"targets": tt.value}, "targets")
+		if got := slices.Contains(targets, db.targetKey()); got != tt.match {
+			t.Errorf("%#v: expected match %v, got %v", tt.value, tt.match, got)
+		}
+	}
+}
+
+func TestGetReplicasAttr(t *testing.T) {
+	tests := []struct {
+		value   types.AttributeValue
+		parsing string
+		want    int
+		wantErr bool
+	}{
+		{&types.AttributeValueMemberN{Value: "3"}, ReplicasParsingStrict, 3, false},
+		{&types.AttributeValueMemberS{Value: "3"}, ReplicasParsingStrict, 3, false},
+		{&types.AttributeValueMemberN{Value: "3.0"}, ReplicasParsingStrict, 0, true},
+		{&types.AttributeValueMemberS{Value: " 3 "}, ReplicasParsingStrict, 0, true},
+		{&types.AttributeValueMemberN{Value: "-1"}, ReplicasParsingStrict, 0, true},
+		{&types.AttributeValueMemberBOOL{Value: true}, ReplicasParsingStrict, 0, true},
+		{&types.AttributeValueMemberN{Value: "3.0"}, ReplicasParsingLenient, 3, false},
+		{&types.AttributeValueMemberS{Value: " 2.5 "}, ReplicasParsingLenient, 3, false},
+		{&types.AttributeValueMemberN{Value: "1e1"}, ReplicasParsingLenient, 10, false},
+		{&types.AttributeValueMemberS{Value: "three"}, ReplicasParsingLenient, 0, true},
+		{&types.AttributeValueMemberS{Value: "NaN"}, ReplicasParsingLenient, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := getReplicasAttr(map[string]types.AttributeValue{"replicas": tt.value}, "replicas", tt.parsing)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%#v (%s): expected %d (error %v), got %d (%v)", tt.value, tt.parsing, tt.want, tt.wantErr, got, err)
+		}
+	}
+	if _, err := getReplicasAttr(map[string]types.AttributeValue{}, "replicas", ReplicasParsingLenient); err == nil {
+		t.Error("expected error for a missing attribute")
+	}
+}
+
+func TestDynamoDBClient_ItemEventInvalidReplicas(t *testing.T) {
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{
+		StartTimeAttr: "start", EndTimeAttr: "end", DesiredReplicasAttr: "desiredReplicas", TimeFormat: "rfc3339",
+	}}
+	item := map[string]types.AttributeValue{
+		"start":          &types.AttributeValueMemberS{Value: "2024-06-11T09:00:00Z"},
+		"end":            &types.AttributeValueMemberS{Value: "2024-06-11T18:00:00Z"},
+		"desiredReplica": &types.AttributeValueMemberN{Value: "3"},
+	}
+	if _, _, err := db.itemEvent(item, time.Date(2024, 6, 11, 12, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("expected error for an active item without desiredReplicas")
+	}
+	if _, ok, err := db.itemEvent(item, time.Date(2024, 6, 11, 20, 0, 0, 0, time.UTC)); ok || err != nil {
+		t.Errorf("expected inactive items not to be validated, got ok=%v, err=%v", ok, err)
+	}
+
+	meta := &DynamoDBMetadata{TableName: "t", Region: "us-east-1", ScanSegments: 1, StartTimeAttr: "s", EndTimeAttr: "e", DesiredReplicasAttr: "d", TimeZone: "UTC", InvalidItemPolicy: "ignore"}
+	if err := meta.validate(); err == nil {
+		t.Error("expected error for unsupported invalidItemPolicy")
+	}
+}
+
+func TestNewDynamoDBMetadata_ReportsAllProblems(t *testing.T) {
+	_, err := NewDynamoDBMetadata(&pb.ScaledObjectRef{ScalerMetadata: map[string]string{
+		"table":           "calendar_events",
+		"startAttribute":  "startEvent",
+		"endAttribute":    "endEvent",
+		"timezone":        "Asia/Tokyo",
+		"replicasParsing": "loose",
+		"desiredReplicas": "desiredReplicas",
+	}})
+	var metadataErr *MetadataError
+	if !errors.As(err, &metadataErr) {
+		t.Fatalf("expected MetadataError, got %v", err)
+	}
+	for _, problem := range []string{"unknown metadata key 'desiredReplicas'", "region is required", "desiredReplicasAttribute is required", "replicasParsing must be one of strict, lenient"} {
+		if !slices.Contains(metadataErr.Problems, problem) {
+			t.Errorf("expected problem '%s' in %v", problem, metadataErr.Problems)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Start and end attributes are stored in one of these formats, or in a Go time
+// layout. Parsed times are normalized to UTC.
+const (
+	DynamoDBTimeRFC3339      = "rfc3339"
+	DynamoDBTimeEpochSeconds = "epochSeconds"
+	DynamoDBTimeEpochMillis  = "epochMillis"
+)
+
+// UTC offsets range from -12:00 to +14:00. RFC3339 strings are compared lexically
+// by DynamoDB, i.e. by their local time, so the server-side filter is widened by
+// these offsets and the exact comparison is done after parsing.
+const (
+	maxUTCOffset = 14*time.Hour + time.Minute
+	minUTCOffset = -12*time.Hour - time.Minute
+)
+
+func validateDynamoDBTimeFormat(format string) error {
+	switch format {
+	case DynamoDBTimeRFC3339, DynamoDBTimeEpochSeconds, DynamoDBTimeEpochMillis:
+		return nil
+	}
+	reference := time.Date(2024, 6, 11, 12, 34, 56, 0, time.UTC)
+	parsed, err := time.Parse(format, reference.Format(format))
+	if err != nil || parsed.Year() != reference.Year() || parsed.YearDay() != reference.YearDay() {
+		return fmt.Errorf("timeFormat must be %s, %s, %s or a Go time layout with a date", DynamoDBTimeRFC3339, DynamoDBTimeEpochSeconds, DynamoDBTimeEpochMillis)
+	}
+	return nil
+}
+
+// timeFilter matches items whose start and end attributes can be active at now.
+// Layouts other than RFC3339 do not sort lexically, so they are not filtered.
+// Epochs stored as S never compare with a number and do not sort lexically
+// either, so they pass the filter and are compared after parsing.
+func (db *DynamoDBClient) timeFilter(expr *dynamoExpression, now time.Time) string {
+	start, end := expr.name(db.Meta.StartTimeAttr), expr.name(db.Meta.EndTimeAttr)
+	switch db.Meta.TimeFormat {
+	case DynamoDBTimeRFC3339, "":
+		latestStart := expr.value(":latestStart", &types.AttributeValueMemberS{Value: now.UTC().Add(maxUTCOffset).Format(time.RFC3339)})
+		earliestEnd := expr.value(":earliestEnd", &types.AttributeValueMemberS{Value: now.UTC().Add(minUTCOffset).Format(time.RFC3339)})
+		return fmt.Sprintf("%s <= %s AND %s >= %s", start, latestStart, end, earliestEnd)
+	case DynamoDBTimeEpochSeconds, DynamoDBTimeEpochMillis:
+		value := now.Unix()
+		if db.Meta.TimeFormat == DynamoDBTimeEpochMillis {
+			value = now.UnixMilli()
+		}
+		nowValue := expr.value(":now", &types.AttributeValueMemberN{Value: strconv.FormatInt(value, 10)})
+		stringType := expr.value(":string", &types.AttributeValueMemberS{Value: string(types.ScalarAttributeTypeS)})
+		return fmt.Sprintf("(attribute_type(%s, %s) OR %s <= %s) AND (attribute_type(%s, %s) OR %s >= %s)",
+			start, stringType, start, nowValue, end, stringType, end, nowValue)
+	default:
+		return ""
+	}
+}
+
+// getTimeAttr parses a start or end attribute. Epoch values may be stored as N
+// or S, layouts without an offset are interpreted in location.
+func (db *DynamoDBClient) getTimeAttr(item map[string]types.AttributeValue, key string, location *time.Location) (time.Time, error) {
+	v, ok := lookupAttr(item, key)
+	if !ok {
+		return time.Time{}, fmt.Errorf("attribute '%s' is missing", key)
+	}
+	var value string
+	switch a := v.(type) {
+	case *types.AttributeValueMemberS:
+		value = a.Value
+	case *types.AttributeValueMemberN:
+		value = a.Value
+	default:
+		return time.Time{}, fmt.Errorf("attribute '%s' must be a string or a number", key)
+	}
+	var t time.Time
+	var err error
+	switch db.Meta.TimeFormat {
+	case DynamoDBTimeRFC3339, "":
+		t, err = time.Parse(time.RFC3339, value)
+	case DynamoDBTimeEpochSeconds, DynamoDBTimeEpochMillis:
+		var n int64
+		n, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if db.Meta.TimeFormat == DynamoDBTimeEpochMillis {
+			t = time.UnixMilli(n)
+		} else {
+			t = time.Unix(n, 0)
+		}
+	default:
+		t, err = time.ParseInLocation(db.Meta.TimeFormat, value, location)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse '%s' as %s: %w", value, db.Meta.TimeFormat, err)
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestValidateDynamoDBTimeFormat(t *testing.T) {
+	for _, format := range []string{"rfc3339", "epochSeconds", "epochMillis", "2006-01-02 15:04", "02/01/2006 15:04 MST"} {
+		if err := validateDynamoDBTimeFormat(format); err != nil {
+			t.Errorf("%s: unexpected error: %v", format, err)
+		}
+	}
+	for _, format := range []string{"epoch", "15:04", "RFC3339"} {
+		if err := validateDynamoDBTimeFormat(format); err == nil {
+			t.Errorf("%s: expected error", format)
+		}
+	}
+}
+
+func TestDynamoDBClient_GetTimeAttr(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	expected := time.Date(2024, 6, 11, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		format string
+		value  types.AttributeValue
+	}{
+		{"rfc3339", &types.AttributeValueMemberS{Value: "2024-06-11T12:00:00+09:00"}},
+		{"rfc3339", &types.AttributeValueMemberS{Value: "2024-06-11T03:00:00Z"}},
+		{"epochSeconds", &types.AttributeValueMemberN{Value: "1718074800"}},
+		{"epochSeconds", &types.AttributeValueMemberS{Value: "1718074800"}},
+		{"epochMillis", &types.AttributeValueMemberN{Value: "1718074800000"}},
+		{"2006-01-02 15:04", &types.AttributeValueMemberS{Value: "2024-06-11 12:00"}},
+	}
+	for _, tt := range tests {
+		db := &DynamoDBClient{Meta: &DynamoDBMetadata{TimeFormat: tt.format}}
+		got, err := db.getTimeAttr(map[string]types.AttributeValue{"start": tt.value}, "start", tokyo)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.format, err)
+			continue
+		}
+		if !got.Equal(expected) || got.Location() != time.UTC {
+			t.Errorf("%s: expected %v, got %v", tt.format, expected, got)
+		}
+	}
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{TimeFormat: "epochSeconds"}}
+	if _, err := db.getTimeAttr(map[string]types.AttributeValue{"start": &types.AttributeValueMemberS{Value: "2024-06-11"}}, "start", tokyo); err == nil {
+		t.Error("expected error for a date with epochSeconds")
+	}
+}
+
+func TestDynamoDBClient_ItemEventMixedOffsets(t *testing.T) {
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{
+		StartTimeAttr: "start", EndTimeAttr: "end", DesiredReplicasAttr: "replicas", TimeFormat: "rfc3339",
+	}}
+	// 10:00-11:00 UTC, stored with different offsets. Lexically "2024-06-11T19:00:00+09:00" > "2024-06-11T10:30:00Z".
+	item := map[string]types.AttributeValue{
+		"start":    &types.AttributeValueMemberS{Value: "2024-06-11T05:00:00-05:00"},
+		"end":      &types.AttributeValueMemberS{Value: "2024-06-11T20:00:00+09:00"},
+		"replicas": &types.AttributeValueMemberN{Value: "3"},
+	}
+	event, ok, err := db.itemEvent(item, time.Date(2024, 6, 11, 10, 30, 0, 0, time.UTC))
+	if err != nil || !ok || event.DesiredReplicas != 3 || !event.StartTime.Equal(time.Date(2024, 6, 11, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected active event, got %+v (ok=%v)", event, ok)
+	}
+	if _, ok, _ := db.itemEvent(item, time.Date(2024, 6, 11, 11, 30, 0, 0, time.UTC)); ok {
+		t.Error("expected event to be inactive after its end")
+	}
+}
+
+func TestDynamoDBClient_TimeFilterEpochMillis(t *testing.T) {
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{StartTimeAttr: "start", EndTimeAttr: "end", TimeFormat: "epochMillis"}}
+	input := db.scanInput(time.Date(2024, 6, 11, 3, 0, 0, 0, time.UTC))
+	if *input.FilterExpression != "(attribute_type(#n0, :string) OR #n0 <= :now) AND (attribute_type(#n1, :string) OR #n1 >= :now)" {
+		t.Errorf("unexpected filter %s", *input.FilterExpression)
+	}
+	if now, ok := input.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberN); !ok || now.Value != "1718074800000" {
+		t.Errorf("unexpected :now value %v", input.ExpressionAttributeValues[":now"])
+	}
+	if s, ok := input.ExpressionAttributeValues[":string"].(*types.AttributeValueMemberS); !ok || s.Value != "S" {
+		t.Errorf("unexpected :string value %v", input.ExpressionAttributeValues[":string"])
+	}
+
+	db.Meta.TimeFormat = "2006-01-02 15:04"
+	if input := db.scanInput(time.Now()); input.FilterExpression != nil || input.ExpressionAttributeNames != nil {
+		t.Error("expected no server-side filter for layouts")
+	}
+}
+
+func TestDynamoDBClient_ItemEventStringEpoch(t *testing.T) {
+	db := &DynamoDBClient{Meta: &DynamoDBMetadata{
+		StartTimeAttr: "start", EndTimeAttr: "end", DesiredReplicasAttr: "replicas", TimeFormat: "epochSeconds",
+	}}
+	// 03:00-04:00 UTC with the epochs stored as strings
+	item := map[string]types.AttributeValue{
+		"start":    &types.AttributeValueMemberS{Value: "1718074800"},
+		"end":      &types.AttributeValueMemberS{Value: "1718078400"},
+		"replicas": &types.AttributeValueMemberN{Value: "3"},
+	}
+	now := time.Date(2024, 6, 11, 3, 30, 0, 0, time.UTC)
+	input := db.scanInput(now)
+	if !strings.Contains(*input.FilterExpression, "attribute_type(#n0, :string) OR") || !strings.Contains(*input.FilterExpression, "attribute_type(#n1, :string) OR") {
+		t.Errorf("expected string epochs to pass the filter, got %s", *input.FilterExpression)
+	}
+	event, ok, err := db.itemEvent(item, now)
+	if err != nil || !ok || event.DesiredReplicas != 3 {
+		t.Errorf("expected active event, got %+v (ok=%v, err=%v)", event, ok, err)
+	}
+	if _, ok, _ := db.itemEvent(item, now.Add(time.Hour)); ok {
+		t.Error("expected event to be inactive after its end")
+	}
+}
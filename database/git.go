@@ -0,0 +1,377 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	pb "calendar-scaler/externalscaler"
+)
+
+type GitMetadata struct {
+	Repository   string        `metadata:"repository,required"`
+	Branch       string        `metadata:"branch"`
+	Path         string        `metadata:"path,required"`
+	Format       string        `metadata:"format"`
+	PullInterval time.Duration `metadata:"pullInterval" default:"1m"`
+	TimeZone     string        `metadata:"timezone,required"`
+	Username     string        `metadata:"username,fromEnv,fromSecret"`
+	Password     Secret        `metadata:"password,fromEnv,fromSecret"`
+	Namespace    string
+	ScaledObject string
+}
+
+func NewGitMetadata(scaledObject *pb.ScaledObjectRef) (*GitMetadata, error) {
+	meta := &GitMetadata{
+		Namespace:    scaledObject.GetNamespace(),
+		ScaledObject: scaledObject.GetName(),
+	}
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), meta, nil)
+	problems.add(meta.validate())
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (meta *GitMetadata) validate() error {
+	problems := validateMetadata(meta)
+	if strings.HasPrefix(meta.Branch, "-") {
+		problems.addf("invalid branch '%s'", meta.Branch)
+	}
+	if filepath.IsAbs(meta.Path) || strings.HasPrefix(filepath.Clean(meta.Path), "..") {
+		problems.addf("path must be relative to the repository root")
+	}
+	if (meta.Username == "") != (meta.Password == "") {
+		problems.addf("username and password must be set together")
+	}
+	if meta.Password != "" && !strings.HasPrefix(meta.Repository, "https://") && !strings.HasPrefix(meta.Repository, "http://") {
+		problems.addf("username and password require an http(s) repository")
+	}
+	if meta.Format != "" {
+		format, err := scheduleFormat(meta.Format, "")
+		problems.add(err)
+		meta.Format = format
+	}
+	return problems.err()
+}
+
+// gitCredentialHelper answers git's credential requests from the environment of
+// the git process, so the credentials never appear in its arguments or config.
+const gitCredentialHelper = `!f() { test "$1" = get && echo "username=$CALENDAR_SCALER_GIT_USERNAME" && echo "password=$CALENDAR_SCALER_GIT_PASSWORD"; }; f`
+
+// gitRepo is a local clone shared by every trigger that uses the same repository,
+// branch and credentials. mu guards the working tree and the state of the clone.
+// syncMu serializes pulls, whose network transfer runs without mu so the other
+// triggers keep evaluating the current revision.
+type gitRepo struct {
+	mu     sync.Mutex
+	syncMu sync.Mutex
+	url    string
+	branch string
+	// display is url without credentials, used in logs, errors and metric labels.
+	display  string
+	env      []string
+	dir      string
+	revision string
+	lastPull time.Time
+	// lastGood holds the last successfully parsed schedule per path/format/timezone,
+	// so a broken commit keeps serving the previous revision.
+	lastGood map[string]gitSchedule
+	// failed holds the revision that could not be parsed per schedule, so the
+	// failure is reported once instead of on every poll.
+	failed map[string]gitFailure
+}
+
+type gitFailure struct {
+	Revision string
+	Err      error
+}
+
+type gitSchedule struct {
+	Revision string
+	Events   []scheduleEvent
+}
+
+var gitRepos = struct {
+	sync.Mutex
+	repos map[string]*gitRepo
+}{repos: map[string]*gitRepo{}}
+
+func getGitRepo(meta *GitMetadata) *gitRepo {
+	key := meta.Repository + "#" + meta.Branch + "#" + meta.Username + "#" + meta.Password.digest()
+	gitRepos.Lock()
+	defer gitRepos.Unlock()
+	if repo, ok := gitRepos.repos[key]; ok {
+		return repo
+	}
+	sum := sha256.Sum256([]byte(key))
+	repo := &gitRepo{
+		url:      meta.Repository,
+		branch:   meta.Branch,
+		display:  redactedURL(meta.Repository),
+		dir:      filepath.Join(os.TempDir(), "calendar-scaler-git", hex.EncodeToString(sum[:8])),
+		lastGood: map[string]gitSchedule{},
+		failed:   map[string]gitFailure{},
+	}
+	if meta.Password != "" {
+		// The empty helper drops helpers configured in the pod
+		repo.env = []string{
+			"GIT_CONFIG_COUNT=2",
+			"GIT_CONFIG_KEY_0=credential.helper",
+			"GIT_CONFIG_VALUE_0=",
+			"GIT_CONFIG_KEY_1=credential.helper",
+			"GIT_CONFIG_VALUE_1=" + gitCredentialHelper,
+			"CALENDAR_SCALER_GIT_USERNAME=" + meta.Username,
+			"CALENDAR_SCALER_GIT_PASSWORD=" + meta.Password.Value(),
+		}
+	}
+	gitRepos.repos[key] = repo
+	return repo
+}
+
+// redactedURL returns repository without the userinfo of its URL.
+func redactedURL(repository string) string {
+	scheme, rest, ok := strings.Cut(repository, "://")
+	if !ok {
+		return repository
+	}
+	host, path := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host, path = rest[:i], rest[i:]
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return scheme + "://" + host + path
+}
+
+type GitClient struct {
+	Meta *GitMetadata
+	repo *gitRepo
+}
+
+func NewGit(meta *GitMetadata) (*GitClient, error) {
+	return &GitClient{Meta: meta, repo: getGitRepo(meta)}, nil
+}
+
+func (db *GitClient) GetEvents() ([]Event, error) {
+	location, err := time.LoadLocation(db.Meta.TimeZone)
+	if err != nil {
+		fmt.Printf("[Git Error] failed to load timezone '%s': %v\n", db.Meta.TimeZone, err)
+		return nil, err
+	}
+	schedule, err := db.loadSchedule(location)
+	if err != nil {
+		return nil, err
+	}
+	targetKey := db.Meta.Namespace + "/" + db.Meta.ScaledObject
+	return activeEvents(schedule.Events, time.Now().In(location), targetKey), nil
+}
+
+func (db *GitClient) loadSchedule(location *time.Location) (gitSchedule, error) {
+	repo := db.repo
+	repo.mu.Lock()
+	due := repo.revision == "" || time.Since(repo.lastPull) >= db.Meta.PullInterval
+	repo.mu.Unlock()
+	var syncErr error
+	if due {
+		syncErr = repo.pull(db.Meta.PullInterval)
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if syncErr != nil {
+		gitSyncFailures.WithLabelValues(repo.display, repo.branch).Inc()
+		if repo.revision == "" {
+			fmt.Printf("[Git Error] failed to clone '%s': %v\n", repo.display, syncErr)
+			return gitSchedule{}, syncErr
+		}
+		fmt.Printf("[Git Error] failed to pull '%s', evaluating revision %s: %v\n", repo.display, repo.revision, syncErr)
+	}
+
+	scheduleKey := db.Meta.Path + "|" + db.Meta.Format + "|" + location.String()
+	lastGood, hasLastGood := repo.lastGood[scheduleKey]
+	if hasLastGood && lastGood.Revision == repo.revision {
+		return lastGood, nil
+	}
+	if failure, ok := repo.failed[scheduleKey]; ok && failure.Revision == repo.revision {
+		if !hasLastGood {
+			return gitSchedule{}, failure.Err
+		}
+		return lastGood, nil
+	}
+
+	events, err := readScheduleFiles(filepath.Join(repo.dir, db.Meta.Path), db.Meta.Format, location)
+	if err != nil {
+		repo.failed[scheduleKey] = gitFailure{Revision: repo.revision, Err: err}
+		gitSyncFailures.WithLabelValues(repo.display, repo.branch).Inc()
+		if !hasLastGood {
+			fmt.Printf("[Git Parse Error] failed to read '%s' at revision %s: %v\n", db.Meta.Path, repo.revision, err)
+			return gitSchedule{}, err
+		}
+		fmt.Printf("[Git Parse Error] failed to read '%s' at revision %s, evaluating revision %s: %v\n",
+			db.Meta.Path, repo.revision, lastGood.Revision, err)
+		return lastGood, nil
+	}
+	delete(repo.failed, scheduleKey)
+	schedule := gitSchedule{Revision: repo.revision, Events: events}
+	repo.lastGood[scheduleKey] = schedule
+	fmt.Printf("[Git] evaluating '%s' of '%s' at revision %s\n", db.Meta.Path, repo.display, repo.revision)
+	return schedule, nil
+}
+
+// pull syncs the repository unless another trigger pulled it within interval
+// while this one waited for syncMu. Only the working tree update runs with mu.
+func (repo *gitRepo) pull(interval time.Duration) error {
+	repo.syncMu.Lock()
+	defer repo.syncMu.Unlock()
+	repo.mu.Lock()
+	due := repo.revision == "" || time.Since(repo.lastPull) >= interval
+	if due {
+		repo.lastPull = time.Now()
+	}
+	repo.mu.Unlock()
+	if !due {
+		return nil
+	}
+	cloned, err := repo.fetch()
+	if err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.checkout(cloned)
+}
+
+// fetch clones the repository on first use and fetches the configured branch
+// afterwards. A fetch only adds objects, the working tree is updated by checkout.
+func (repo *gitRepo) fetch() (bool, error) {
+	ref := repo.branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := os.Stat(filepath.Join(repo.dir, ".git")); err != nil {
+		if err := os.RemoveAll(repo.dir); err != nil {
+			return false, err
+		}
+		args := []string{"clone", "--quiet", "--depth", "1"}
+		if repo.branch != "" {
+			args = append(args, "--branch", repo.branch)
+		}
+		_, err := repo.git("", append(args, "--", repo.url, repo.dir)...)
+		return err == nil, err
+	}
+	_, err := repo.git(repo.dir, "fetch", "--quiet", "--depth", "1", "origin", ref)
+	return false, err
+}
+
+// checkout moves the working tree to the fetched revision, unless it was just
+// cloned. It must be called with mu locked.
+func (repo *gitRepo) checkout(cloned bool) error {
+	if !cloned {
+		if _, err := runGit(repo.dir, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+			return err
+		}
+	}
+	revision, err := runGit(repo.dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if revision != repo.revision {
+		if repo.revision != "" {
+			gitRevisionInfo.DeletePartialMatch(prometheus.Labels{"repository": repo.display, "branch": repo.branch})
+		}
+		gitRevisionInfo.WithLabelValues(repo.display, repo.branch, revision).Set(1)
+		repo.revision = revision
+	}
+	return nil
+}
+
+// git runs a git command that talks to the remote, with the credentials of the
+// repository. The URL is redacted from errors.
+func (repo *gitRepo) git(dir string, args ...string) (string, error) {
+	out, err := runGitEnv(dir, repo.env, args...)
+	if err != nil && repo.display != repo.url {
+		return "", errors.New(strings.ReplaceAll(err.Error(), repo.url, repo.display))
+	}
+	return out, err
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	return runGitEnv(dir, nil, args...)
+}
+
+func runGitEnv(dir string, env []string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// readScheduleFiles parses a schedule file, or every schedule file of a directory.
+func readScheduleFiles(path string, format string, location *time.Location) ([]scheduleEvent, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = nil
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if _, err := scheduleFormat(format, entry.Name()); err == nil {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		sort.Strings(files)
+	}
+	var events []scheduleEvent
+	for _, file := range files {
+		fileFormat, err := scheduleFormat(format, file)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseSchedule(data, fileFormat, location)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		events = append(events, parsed...)
+	}
+	return events, nil
+}
+
+func (db *GitClient) Close() error {
+	// The clone is shared between requests and kept for the next pull
+	return nil
+}
@@ -0,0 +1,283 @@
+package database
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "calendar-scaler/externalscaler"
+)
+
+const (
+	googleCalendarDefaultEndpoint      = "https://www.googleapis.com/calendar/v3"
+	googleCalendarDefaultTokenEndpoint = "https://oauth2.googleapis.com/token"
+	googleCalendarScope                = "https://www.googleapis.com/auth/calendar.readonly"
+)
+
+type GoogleCalendarMetadata struct {
+	CalendarID              string `metadata:"calendarId,required"`
+	CredentialsFile         string `metadata:"credentialsFile"`
+	Credentials             Secret `metadata:"credentials,fromSecret"`
+	Endpoint                string `metadata:"endpoint"`
+	TokenEndpoint           string `metadata:"tokenEndpoint"`
+	DesiredReplicasProperty string `metadata:"desiredReplicasProperty" default:"desiredReplicas"`
+	DesiredReplicasTag      string `metadata:"desiredReplicasTag" default:"desiredReplicas"`
+	TargetProperty          string `metadata:"targetProperty"`
+	TimeZone                string `metadata:"timezone,required"`
+	Namespace               string
+	ScaledObject            string
+}
+
+func NewGoogleCalendarMetadata(scaledObject *pb.ScaledObjectRef) (*GoogleCalendarMetadata, error) {
+	meta := &GoogleCalendarMetadata{
+		Namespace:    scaledObject.GetNamespace(),
+		ScaledObject: scaledObject.GetName(),
+	}
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), meta, nil)
+	problems.add(meta.validate())
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (meta *GoogleCalendarMetadata) validate() error {
+	problems := validateMetadata(meta)
+	if (meta.CredentialsFile == "") == (meta.Credentials == "") {
+		problems.addf("exactly one of credentialsFile or credentials is required")
+	}
+	if meta.Endpoint == "" {
+		meta.Endpoint = googleCalendarDefaultEndpoint
+	}
+	return problems.err()
+}
+
+// googleServiceAccount is the subset of a service account JSON key used for the JWT bearer flow.
+type googleServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type GoogleCalendarClient struct {
+	Meta    *GoogleCalendarMetadata
+	account *googleServiceAccount
+	key     *rsa.PrivateKey
+}
+
+func NewGoogleCalendar(meta *GoogleCalendarMetadata) (*GoogleCalendarClient, error) {
+	source, data := "credentials", []byte(meta.Credentials.Value())
+	if meta.CredentialsFile != "" {
+		source = "credentialsFile"
+		var err error
+		if data, err = os.ReadFile(meta.CredentialsFile); err != nil {
+			return nil, fmt.Errorf("failed to read credentialsFile: %w", err)
+		}
+	}
+	var account googleServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		// The JSON error may quote the key, so it is not wrapped
+		return nil, fmt.Errorf("failed to parse %s: not a service account JSON key", source)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("%s must contain client_email and private_key", source)
+	}
+	key, err := parseRSAPrivateKey(account.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if meta.TokenEndpoint == "" {
+		meta.TokenEndpoint = account.TokenURI
+	}
+	if meta.TokenEndpoint == "" {
+		meta.TokenEndpoint = googleCalendarDefaultTokenEndpoint
+	}
+	return &GoogleCalendarClient{Meta: meta, account: &account, key: key}, nil
+}
+
+func parseRSAPrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("private_key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private_key is not an RSA key")
+	}
+	return key, nil
+}
+
+// signedAssertion builds the RS256 signed JWT used for the service account token request.
+func (c *GoogleCalendarClient) signedAssertion(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   c.account.ClientEmail,
+		"scope": googleCalendarScope,
+		"aud":   c.Meta.TokenEndpoint,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (c *GoogleCalendarClient) token(ctx context.Context) (string, error) {
+	// The key includes the private key, so a trigger with the same client_email
+	// but without the key cannot get the cached token
+	key := "google/" + c.Meta.TokenEndpoint + "/" + c.account.ClientEmail + "/" + Secret(c.account.PrivateKey).digest()
+	return getAccessToken(key, func() (accessToken, error) {
+		assertion, err := c.signedAssertion(time.Now())
+		if err != nil {
+			return accessToken{}, err
+		}
+		return requestAccessToken(ctx, c.Meta.TokenEndpoint, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	})
+}
+
+type googleEventTime struct {
+	Date     string `json:"date"`
+	DateTime string `json:"dateTime"`
+}
+
+type googleEvent struct {
+	ID                 string          `json:"id"`
+	Status             string          `json:"status"`
+	Description        string          `json:"description"`
+	Start              googleEventTime `json:"start"`
+	End                googleEventTime `json:"end"`
+	ExtendedProperties struct {
+		Private map[string]string `json:"private"`
+		Shared  map[string]string `json:"shared"`
+	} `json:"extendedProperties"`
+}
+
+type googleEventList struct {
+	Items         []googleEvent `json:"items"`
+	NextPageToken string        `json:"nextPageToken"`
+}
+
+func (c *GoogleCalendarClient) GetEvents() ([]Event, error) {
+	location, err := time.LoadLocation(c.Meta.TimeZone)
+	if err != nil {
+		fmt.Printf("[GoogleCalendar Error] failed to load timezone '%s': %v\n", c.Meta.TimeZone, err)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), calendarAPITimeout)
+	defer cancel()
+	token, err := c.token(ctx)
+	if err != nil {
+		fmt.Printf("[GoogleCalendar Error] failed to get access token: %v\n", err)
+		return nil, err
+	}
+	now := time.Now().In(location)
+	query := url.Values{
+		"timeMin":      {now.Format(time.RFC3339)},
+		"timeMax":      {now.Add(time.Second).Format(time.RFC3339)},
+		"singleEvents": {"true"},
+		"timeZone":     {c.Meta.TimeZone},
+	}
+	var events []Event
+	targetKey := c.Meta.Namespace + "/" + c.Meta.ScaledObject
+	for {
+		requestURL := fmt.Sprintf("%s/calendars/%s/events?%s",
+			strings.TrimRight(c.Meta.Endpoint, "/"), url.PathEscape(c.Meta.CalendarID), query.Encode())
+		var list googleEventList
+		if err := getJSON(ctx, requestURL, token, nil, &list); err != nil {
+			fmt.Printf("[GoogleCalendar Error] failed to list events of '%s': %v\n", c.Meta.CalendarID, err)
+			return nil, err
+		}
+		for _, item := range list.Items {
+			if item.Status == "cancelled" {
+				continue
+			}
+			if c.Meta.TargetProperty != "" && !containsTarget(item.property(c.Meta.TargetProperty), targetKey) {
+				continue
+			}
+			event, err := item.toEvent(c.Meta, location)
+			if err != nil {
+				fmt.Printf("[GoogleCalendar Parse Error] event '%s': %v\n", item.ID, err)
+				continue
+			}
+			events = append(events, event)
+		}
+		if list.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", list.NextPageToken)
+	}
+	return events, nil
+}
+
+func (item *googleEvent) property(key string) string {
+	if v, ok := item.ExtendedProperties.Private[key]; ok {
+		return v
+	}
+	return item.ExtendedProperties.Shared[key]
+}
+
+func (item *googleEvent) toEvent(meta *GoogleCalendarMetadata, location *time.Location) (Event, error) {
+	start, err := item.Start.parse(location)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to parse start: %w", err)
+	}
+	end, err := item.End.parse(location)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to parse end: %w", err)
+	}
+	var desiredReplicas int
+	if v := item.property(meta.DesiredReplicasProperty); v != "" {
+		desiredReplicas, err = strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return Event{}, fmt.Errorf("invalid extended property '%s': %w", meta.DesiredReplicasProperty, err)
+		}
+	} else if n, ok := parseReplicasTag(item.Description, meta.DesiredReplicasTag); ok {
+		desiredReplicas = n
+	} else {
+		return Event{}, fmt.Errorf("no '%s' extended property or description tag", meta.DesiredReplicasProperty)
+	}
+	return Event{
+		StartTime:       start,
+		EndTime:         end,
+		DesiredReplicas: desiredReplicas,
+	}, nil
+}
+
+// parse handles both timed events (dateTime) and all-day events (date).
+func (t googleEventTime) parse(location *time.Location) (time.Time, error) {
+	if t.DateTime != "" {
+		return time.Parse(time.RFC3339, t.DateTime)
+	}
+	return time.ParseInLocation("2006-01-02", t.Date, location)
+}
+
+func (c *GoogleCalendarClient) Close() error {
+	// No explicit Close required for Google Calendar
+	return nil
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewGoogleCalendarMetadata_RequiredFields(t *testing.T) {
+	scaledObject := &pb.ScaledObjectRef{
+		ScalerMetadata: map[string]string{
+			"calendarId": "team@example.com",
+			"timezone":   "Asia/Tokyo",
+		},
+	}
+	if _, err := NewGoogleCalendarMetadata(scaledObject); err == nil {
+		t.Error("expected error for missing credentialsFile")
+	}
+	scaledObject.ScalerMetadata["credentialsFile"] = "/secrets/key.json"
+	scaledObject.ScalerMetadata["credentials"] = "{}"
+	if _, err := NewGoogleCalendarMetadata(scaledObject); err == nil {
+		t.Error("expected error for both credentialsFile and credentials")
+	}
+	delete(scaledObject.ScalerMetadata, "credentials")
+	meta, err := NewGoogleCalendarMetadata(scaledObject)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.Endpoint != googleCalendarDefaultEndpoint {
+		t.Errorf("expected default endpoint, got '%s'", meta.Endpoint)
+	}
+}
+
+func TestGoogleCalendarClient_GetEvents(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.FormValue("assertion") == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token":"google-token","expires_in":3600}`))
+	})
+	mux.HandleFunc("/calendars/team@example.com/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer google-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("pageToken") == "" {
+			w.Write([]byte(`{"items":[
+				{"id":"a","start":{"dateTime":"2024-06-11T12:00:00+09:00"},"end":{"dateTime":"2024-06-11T13:00:00+09:00"},
+				 "extendedProperties":{"private":{"desiredReplicas":"4","target":"default/app"}}},
+				{"id":"b","start":{"dateTime":"2024-06-11T12:00:00+09:00"},"end":{"dateTime":"2024-06-11T13:00:00+09:00"},
+				 "extendedProperties":{"shared":{"target":"default/other"}},"description":"desiredReplicas: 9"}
+			],"nextPageToken":"next"}`))
+			return
+		}
+		w.Write([]byte(`{"items":[
+			{"id":"c","start":{"date":"2024-06-11"},"end":{"date":"2024-06-12"},
+			 "extendedProperties":{"shared":{"target":"default/app"}},"description":"Sale day\ndesiredReplicas=2"},
+			{"id":"d","start":{"date":"2024-06-11"},"end":{"date":"2024-06-12"},
+			 "extendedProperties":{"shared":{"target":"default/app"}},"description":"no tag"}
+		]}`))
+	})
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, key)})
+	credentials, _ := json.Marshal(map[string]string{
+		"client_email": "scaler@example.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    server.URL + "/token",
+	})
+	credentialsFile := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(credentialsFile, credentials, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := NewGoogleCalendarMetadata(&pb.ScaledObjectRef{
+		Name:      "app",
+		Namespace: "default",
+		ScalerMetadata: map[string]string{
+			"calendarId":      "team@example.com",
+			"credentialsFile": credentialsFile,
+			"endpoint":        server.URL,
+			"targetProperty":  "target",
+			"timezone":        "Asia/Tokyo",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client, err := NewGoogleCalendar(meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events, err := client.GetEvents()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].DesiredReplicas != 4 || events[1].DesiredReplicas != 2 {
+		t.Errorf("unexpected desired replicas: %+v", events)
+	}
+	if events[1].StartTime.Location().String() != "Asia/Tokyo" {
+		t.Errorf("expected all-day event in calendar timezone, got %s", events[1].StartTime.Location())
+	}
+}
+
+func TestNewGoogleCalendar_Credentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, key)})
+	credentials, _ := json.Marshal(map[string]string{
+		"client_email": "scaler@example.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+	})
+	client, err := NewGoogleCalendar(&GoogleCalendarMetadata{Credentials: Secret(credentials)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.Meta.TokenEndpoint != googleCalendarDefaultTokenEndpoint {
+		t.Errorf("expected default token endpoint, got '%s'", client.Meta.TokenEndpoint)
+	}
+
+	_, err = NewGoogleCalendar(&GoogleCalendarMetadata{Credentials: "hunter2"})
+	if err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("expected error without the credentials, got %v", err)
+	}
+}
+
+func mustMarshalPKCS8(t *testing.T, key *rsa.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestGoogleCalendarClient_TokenCacheRequiresKey(t *testing.T) {
+	credentials := func(key *rsa.PrivateKey) Secret {
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, key)})
+		data, _ := json.Marshal(map[string]string{"client_email": "victim@example.iam.gserviceaccount.com", "private_key": string(keyPEM)})
+		return Secret(data)
+	}
+	victimKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only assertions signed by the victim's key are accepted
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(&victimKey.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token":"victim-token","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	victim, err := NewGoogleCalendar(&GoogleCalendarMetadata{Credentials: credentials(victimKey), TokenEndpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := victim.token(context.Background()); err != nil || token != "victim-token" {
+		t.Fatalf("expected victim-token, got '%s' (err=%v)", token, err)
+	}
+	other, err := NewGoogleCalendar(&GoogleCalendarMetadata{Credentials: credentials(otherKey), TokenEndpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := other.token(context.Background()); err == nil {
+		t.Errorf("expected another key for the same client_email to miss the token cache, got '%s'", token)
+	}
+}
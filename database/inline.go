@@ -0,0 +1,72 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	pb "calendar-scaler/externalscaler"
+)
+
+// InlineMetadata holds a schedule written directly in the trigger metadata.
+type InlineMetadata struct {
+	Schedule     string `metadata:"schedule,required"`
+	Format       string `metadata:"format" default:"yaml"`
+	TimeZone     string `metadata:"timezone,required"`
+	Namespace    string
+	ScaledObject string
+
+	location *time.Location
+	events   []scheduleEvent
+}
+
+func NewInlineMetadata(scaledObject *pb.ScaledObjectRef) (*InlineMetadata, error) {
+	meta := &InlineMetadata{
+		Namespace:    scaledObject.GetNamespace(),
+		ScaledObject: scaledObject.GetName(),
+	}
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), meta, nil)
+	problems.add(meta.validate())
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// validate also parses the schedule, so a malformed schedule is rejected with the trigger.
+func (meta *InlineMetadata) validate() error {
+	problems := validateMetadata(meta)
+	format, err := scheduleFormat(meta.Format, "")
+	problems.add(err)
+	meta.Format = format
+	location, err := time.LoadLocation(meta.TimeZone)
+	if err != nil {
+		problems.addf("invalid timezone '%s': %v", meta.TimeZone, err)
+	}
+	if len(problems) > 0 {
+		return problems.err()
+	}
+	events, err := parseSchedule([]byte(meta.Schedule), meta.Format, location)
+	if err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+	meta.location = location
+	meta.events = events
+	return nil
+}
+
+type InlineSchedule struct {
+	Meta *InlineMetadata
+}
+
+func NewInline(meta *InlineMetadata) (*InlineSchedule, error) {
+	return &InlineSchedule{Meta: meta}, nil
+}
+
+func (db *InlineSchedule) GetEvents() ([]Event, error) {
+	targetKey := db.Meta.Namespace + "/" + db.Meta.ScaledObject
+	return activeEvents(db.Meta.events, time.Now().In(db.Meta.location), targetKey), nil
+}
+
+func (db *InlineSchedule) Close() error {
+	return nil
+}
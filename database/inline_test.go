@@ -0,0 +1,35 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"testing"
+)
+
+func TestNewInlineMetadata_ParsesSchedule(t *testing.T) {
+	scaledObject := &pb.ScaledObjectRef{
+		Name:      "app",
+		Namespace: "default",
+		ScalerMetadata: map[string]string{
+			"schedule": "- cron: \"* * * * *\"\n  duration: 2m\n  desiredReplicas: 4\n" +
+				"- start: 2000-01-01T00:00:00Z\n  end: 2000-01-02T00:00:00Z\n  desiredReplicas: 9\n",
+			"timezone": "Asia/Tokyo",
+		},
+	}
+	meta, err := NewInlineMetadata(scaledObject)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db, _ := NewInline(meta)
+	events, err := db.GetEvents()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 1 || events[0].DesiredReplicas != 4 {
+		t.Errorf("expected only the recurring event to be active, got %+v", events)
+	}
+
+	scaledObject.ScalerMetadata["schedule"] = "- cron: \"0 9 * *\"\n  duration: 9h\n  desiredReplicas: 4\n"
+	if _, err := NewInlineMetadata(scaledObject); err == nil {
+		t.Error("expected error for invalid cron expression")
+	}
+}
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Parameters with a SecretRef key (e.g. passwordSecretRef: calendar-db/password)
+// are read from a Kubernetes secret in the namespace of the ScaledObject. The
+// scaler reads the secret with its own service account, so only secrets matching
+// KUBERNETES_SECRET_ALLOWLIST can be referenced. Each secret is read once and
+// then kept up to date with a watch, so rotated credentials are picked up
+// without restarting the scaler.
+
+const (
+	kubernetesServiceAccountDir   = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesSecretAllowlistEnv  = "KUBERNETES_SECRET_ALLOWLIST"
+	kubernetesSecretReadTimeout   = 5 * time.Second
+	kubernetesSecretRetryInterval = 5 * time.Second
+	kubernetesSecretWatchTimeout  = 5 * time.Minute
+	// kubernetesSecretIdleTimeout stops watching a secret that has not been
+	// read, e.g. after its ScaledObject was deleted.
+	kubernetesSecretIdleTimeout = 10 * time.Minute
+)
+
+var errKubernetesWatchExpired = errors.New("watch expired")
+
+// kubernetesClient is a minimal client of the Kubernetes API using the service
+// account of the pod.
+type kubernetesClient struct {
+	host      string
+	tokenFile string
+	client    *http.Client
+}
+
+func newInClusterKubernetesClient() (*kubernetesClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster")
+	}
+	ca, err := os.ReadFile(path.Join(kubernetesServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("service account CA is not PEM encoded")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &kubernetesClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		tokenFile: path.Join(kubernetesServiceAccountDir, "token"),
+		client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// get sends a GET request for the API path. The token is read for every
+// request because projected service account tokens are rotated.
+func (c *kubernetesClient) get(ctx context.Context, apiPath string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.host+apiPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	req.Header.Set("Accept", "application/json")
+	return c.client.Do(req)
+}
+
+// kubernetesStatusError returns the message of a Kubernetes Status response.
+func kubernetesStatusError(resp *http.Response) error {
+	var status struct {
+		Message string `json:"message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, &status) != nil || status.Message == "" {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("%s (status %d)", status.Message, resp.StatusCode)
+}
+
+type kubernetesSecret struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"`
+}
+
+// watchedSecret is the cached content of one secret. Its fields are guarded by
+// kubernetesSecrets.
+type watchedSecret struct {
+	key       string
+	namespace string
+	name      string
+	client    *kubernetesClient
+
+	data     map[string][]byte
+	found    bool
+	err      error
+	ready    chan struct{}
+	loaded   bool
+	lastRead time.Time
+}
+
+// kubernetesSecrets holds the client and one watcher per namespace and secret.
+var kubernetesSecrets = struct {
+	sync.Mutex
+	client *kubernetesClient
+	m      map[string]*watchedSecret
+}{m: map[string]*watchedSecret{}}
+
+// secretRefAllowed reports whether the secret matches an entry of the
+// comma-separated allow-list. Entries are namespace/name patterns as used by
+// path.Match, e.g. team-a/* or */calendar-scaler-*.
+func secretRefAllowed(allowlist, namespace, name string) bool {
+	for _, pattern := range splitList(allowlist) {
+		if ok, err := path.Match(pattern, namespace+"/"+name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupSecretRef returns the value of ref, given as name/key, from a secret in
+// namespace.
+func lookupSecretRef(namespace, ref string) (string, error) {
+	name, key, ok := strings.Cut(ref, "/")
+	if !ok || name == "" || key == "" || strings.Contains(key, "/") {
+		return "", errors.New("must be in the form name/key")
+	}
+	if namespace == "" {
+		return "", errors.New("requires the namespace of the ScaledObject")
+	}
+	if !secretRefAllowed(os.Getenv(kubernetesSecretAllowlistEnv), namespace, name) {
+		return "", fmt.Errorf("secret '%s/%s' is not allowed by %s", namespace, name, kubernetesSecretAllowlistEnv)
+	}
+	s, err := watchSecret(namespace, name)
+	if err != nil {
+		return "", err
+	}
+	select {
+	case <-s.ready:
+	case <-time.After(kubernetesSecretReadTimeout):
+		return "", fmt.Errorf("timed out reading secret '%s/%s'", namespace, name)
+	}
+
+	kubernetesSecrets.Lock()
+	defer kubernetesSecrets.Unlock()
+	switch value, exists := s.data[key]; {
+	case s.err != nil:
+		return "", fmt.Errorf("failed to read secret '%s/%s': %w", namespace, name, s.err)
+	case !s.found:
+		return "", fmt.Errorf("secret '%s/%s' not found", namespace, name)
+	case !exists:
+		return "", fmt.Errorf("secret '%s/%s' has no key '%s'", namespace, name, key)
+	default:
+		return string(value), nil
+	}
+}
+
+// watchSecret returns the watcher of the secret, starting it if necessary.
+func watchSecret(namespace, name string) (*watchedSecret, error) {
+	key := namespace + "/" + name
+	kubernetesSecrets.Lock()
+	defer kubernetesSecrets.Unlock()
+	s, ok := kubernetesSecrets.m[key]
+	if !ok {
+		if kubernetesSecrets.client == nil {
+			client, err := newInClusterKubernetesClient()
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+			}
+			kubernetesSecrets.client = client
+		}
+		s = &watchedSecret{
+			key:       key,
+			namespace: namespace,
+			name:      name,
+			client:    kubernetesSecrets.client,
+			ready:     make(chan struct{}),
+		}
+		kubernetesSecrets.m[key] = s
+		go s.run()
+	}
+	s.lastRead = time.Now()
+	return s, nil
+}
+
+// run reads the secret and watches it until it is idle. After an error, e.g. an
+// expired resource version, the secret is read again.
+func (s *watchedSecret) run() {
+	for {
+		resourceVersion, err := s.read()
+		if err == nil {
+			err = s.watch(resourceVersion)
+		}
+		// After an error the last content is kept, it is still the best we know
+		kubernetesSecrets.Lock()
+		idle := time.Since(s.lastRead) > kubernetesSecretIdleTimeout
+		if idle && kubernetesSecrets.m[s.key] == s {
+			delete(kubernetesSecrets.m, s.key)
+		}
+		kubernetesSecrets.Unlock()
+		if idle {
+			return
+		}
+		if err != nil && !errors.Is(err, errKubernetesWatchExpired) {
+			fmt.Printf("[Kubernetes Error] secret '%s': %v\n", s.key, err)
+			time.Sleep(kubernetesSecretRetryInterval)
+		}
+	}
+}
+
+// set must be called with kubernetesSecrets locked.
+func (s *watchedSecret) set(secret *kubernetesSecret) {
+	s.err = nil
+	s.found = secret != nil
+	s.data = nil
+	if secret != nil {
+		s.data = secret.Data
+	}
+	if !s.loaded {
+		s.loaded = true
+		close(s.ready)
+	}
+}
+
+// read gets the secret and returns its resource version. A missing secret is
+// not an error, it is watched until it is created.
+func (s *watchedSecret) read() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesSecretReadTimeout)
+	defer cancel()
+	resp, err := s.client.get(ctx, "/api/v1/namespaces/"+url.PathEscape(s.namespace)+"/secrets", url.Values{
+		"fieldSelector": {"metadata.name=" + s.name},
+	})
+	if err != nil {
+		s.fail(err)
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := kubernetesStatusError(resp)
+		s.fail(err)
+		return "", err
+	}
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []kubernetesSecret `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		err = fmt.Errorf("failed to decode secret list: %w", err)
+		s.fail(err)
+		return "", err
+	}
+	kubernetesSecrets.Lock()
+	defer kubernetesSecrets.Unlock()
+	if len(list.Items) == 0 {
+		s.set(nil)
+	} else {
+		s.set(&list.Items[0])
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// fail records an error of the first read, so lookups do not wait for the
+// timeout.
+func (s *watchedSecret) fail(err error) {
+	kubernetesSecrets.Lock()
+	defer kubernetesSecrets.Unlock()
+	if !s.loaded {
+		s.err = err
+		s.loaded = true
+		close(s.ready)
+	}
+}
+
+// watch applies the events of the secret from resourceVersion until the watch
+// times out, after which run reads the secret again. The list of the namespace
+// is watched with a field selector, which Kubernetes authorizes like a request
+// for the single secret.
+func (s *watchedSecret) watch(resourceVersion string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesSecretWatchTimeout+kubernetesSecretReadTimeout)
+	defer cancel()
+	resp, err := s.client.get(ctx, "/api/v1/namespaces/"+url.PathEscape(s.namespace)+"/secrets", url.Values{
+		"watch":               {"true"},
+		"fieldSelector":       {"metadata.name=" + s.name},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {fmt.Sprint(int(kubernetesSecretWatchTimeout.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return kubernetesStatusError(resp)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				// The server closed the watch after timeoutSeconds
+				return nil
+			}
+			return fmt.Errorf("failed to decode watch event: %w", err)
+		}
+		var secret kubernetesSecret
+		if err := json.Unmarshal(event.Object, &secret); err != nil {
+			return fmt.Errorf("failed to decode watch event: %w", err)
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			kubernetesSecrets.Lock()
+			s.set(&secret)
+			kubernetesSecrets.Unlock()
+		case "DELETED":
+			kubernetesSecrets.Lock()
+			s.set(nil)
+			kubernetesSecrets.Unlock()
+		case "ERROR":
+			// Usually 410 Gone: the resource version is too old, read again
+			return errKubernetesWatchExpired
+		}
+	}
+}
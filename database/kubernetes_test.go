@@ -0,0 +1,167 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKubernetesSecrets serves the secret 'calendar-db' in namespace 'team-a'
+// and sends the events written to events to its watchers.
+func fakeKubernetesSecrets(t *testing.T, events chan string) *kubernetesClient {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/team-a/secrets" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"kind":"Status","message":"secrets is forbidden"}`)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("watch") != "true" {
+			items := "[]"
+			if query.Get("fieldSelector") == "metadata.name=calendar-db" {
+				items = `[{"metadata":{"name":"calendar-db","resourceVersion":"1"},"data":{"password":"b2xk"}}]`
+			}
+			fmt.Fprintf(w, `{"metadata":{"resourceVersion":"1"},"items":%s}`, items)
+			return
+		}
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-events:
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			case <-done:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(kubernetesSecretAllowlistEnv, "team-a/calendar-*")
+	client := &kubernetesClient{host: server.URL, tokenFile: tokenFile, client: server.Client()}
+	kubernetesSecrets.Lock()
+	kubernetesSecrets.client = client
+	kubernetesSecrets.m = map[string]*watchedSecret{}
+	kubernetesSecrets.Unlock()
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+		kubernetesSecrets.Lock()
+		for _, s := range kubernetesSecrets.m {
+			// Let the watchers stop after their current attempt
+			s.lastRead = time.Time{}
+		}
+		kubernetesSecrets.client = nil
+		kubernetesSecrets.m = map[string]*watchedSecret{}
+		kubernetesSecrets.Unlock()
+	})
+	return client
+}
+
+func TestSecretRefAllowed(t *testing.T) {
+	allowlist := "team-a/*, */calendar-scaler-*"
+	tests := []struct {
+		namespace, name string
+		want            bool
+	}{
+		{"team-a", "db", true},
+		{"team-b", "calendar-scaler-db", true},
+		{"team-b", "db", false},
+		{"kube-system", "calendar", false},
+	}
+	for _, tt := range tests {
+		if got := secretRefAllowed(allowlist, tt.namespace, tt.name); got != tt.want {
+			t.Errorf("secretRefAllowed(%s, %s) = %v, want %v", tt.namespace, tt.name, got, tt.want)
+		}
+	}
+	if secretRefAllowed("", "team-a", "db") {
+		t.Error("expected no secret to be allowed by an empty allow-list")
+	}
+}
+
+func TestLookupSecretRef(t *testing.T) {
+	events := make(chan string)
+	fakeKubernetesSecrets(t, events)
+
+	value, err := lookupSecretRef("team-a", "calendar-db/password")
+	if err != nil || value != "old" {
+		t.Fatalf("expected 'old', got '%s' (err=%v)", value, err)
+	}
+
+	events <- `{"type":"MODIFIED","object":{"metadata":{"name":"calendar-db","resourceVersion":"2"},"data":{"password":"bmV3"}}}`
+	deadline := time.Now().Add(5 * time.Second)
+	for value != "new" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		value, _ = lookupSecretRef("team-a", "calendar-db/password")
+	}
+	if value != "new" {
+		t.Errorf("expected the watched update 'new', got '%s'", value)
+	}
+
+	tests := []struct {
+		namespace, ref, wantErr string
+	}{
+		{"team-a", "calendar-db", "must be in the form name/key"},
+		{"team-a", "calendar-db/user", "has no key 'user'"},
+		{"team-a", "calendar-cache/password", "not found"},
+		{"team-a", "postgres/password", "not allowed"},
+		{"team-b", "calendar-db/password", "not allowed"},
+		{"", "calendar-db/password", "requires the namespace"},
+	}
+	for _, tt := range tests {
+		if _, err := lookupSecretRef(tt.namespace, tt.ref); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s %s: expected error containing '%s', got %v", tt.namespace, tt.ref, tt.wantErr, err)
+		}
+	}
+
+	t.Setenv(kubernetesSecretAllowlistEnv, "*/calendar-*")
+	if _, err := lookupSecretRef("team-b", "calendar-db/password"); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("expected the API error, got %v", err)
+	}
+}
+
+func TestNewPostgreSQLMetadata_PasswordSecretRef(t *testing.T) {
+	fakeKubernetesSecrets(t, make(chan string))
+	t.Setenv("POSTGRES_PASSWORD", "from-env")
+	metadata := map[string]string{
+		"host":                  "localhost",
+		"port":                  "5432",
+		"username":              "user",
+		"passwordSecretRef":     "calendar-db/password",
+		"passwordEnv":           "POSTGRES_PASSWORD",
+		"database":              "db",
+		"table":                 "events",
+		"startColumn":           "start",
+		"endColumn":             "end",
+		"desiredReplicasColumn": "replicas",
+		"timezone":              "UTC",
+	}
+	meta, err := NewPostgreSQLMetadata(&pb.ScaledObjectRef{Namespace: "team-a", ScalerMetadata: metadata})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.Password.Value() != "old" {
+		t.Errorf("expected the secret to take precedence over passwordEnv, got '%s'", meta.Password.Value())
+	}
+
+	metadata["passwordSecretRef"] = "calendar-db/missing"
+	_, err = NewPostgreSQLMetadata(&pb.ScaledObjectRef{Namespace: "team-a", ScalerMetadata: metadata})
+	if err == nil || !strings.Contains(err.Error(), "passwordSecretRef: secret 'team-a/calendar-db' has no key 'missing'") {
+		t.Errorf("expected passwordSecretRef error, got %v", err)
+	}
+}
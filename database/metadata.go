@@ -0,0 +1,299 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The trigger metadata of every backend is declared with struct tags on its
+// metadata type:
+//
+//	metadata:"key[,required][,fromEnv][,fromSecret]"
+//	default:"value"
+//	enum:"a|b|c"
+//	env:"legacyKey"
+//
+// With fromEnv a value that is not in the metadata is read from the environment
+// variable named by keyFromEnv. env names an additional key that works like
+// keyFromEnv. With fromSecret it is read from the Kubernetes secret and key
+// given by keySecretRef (name/key) in the namespace of the ScaledObject, which
+// takes precedence over the environment. Supported field types are string,
+// Secret, bool, int, time.Duration and []string (comma-separated). Fields of
+// embedded structs are handled like fields of the struct itself.
+//
+// decodeMetadata sets the fields from the trigger metadata and rejects unknown
+// keys, validateMetadata applies defaults and checks required and enum fields.
+// Both report every problem instead of only the first one.
+
+// commonMetadataKeys are read by KEDA or main rather than by a backend.
+var commonMetadataKeys = []string{
+	"type", "scalerAddress", "tlsCertFile", "caCert", "tlsClientCert", "tlsClientKey", "unsafeSsl",
+	"scaleToZeroOnNoEvents", "aggregation", "streamInterval",
+}
+
+// Secret is a credential from the trigger metadata, e.g. a parameter of a KEDA
+// TriggerAuthentication, or from the environment. It is formatted as
+// [redacted], so it cannot end up in logs or error messages by accident.
+type Secret string
+
+// Value returns the secret itself.
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// digest identifies the secret in cache keys without containing it.
+func (s Secret) digest() string {
+	if s == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// MetadataError lists all problems found in the metadata of a trigger.
+type MetadataError struct {
+	Problems []string
+}
+
+func (e *MetadataError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+type metadataProblems []string
+
+func (p *metadataProblems) add(err error) {
+	var metadataErr *MetadataError
+	switch {
+	case err == nil:
+	case errors.As(err, &metadataErr):
+		*p = append(*p, metadataErr.Problems...)
+	default:
+		*p = append(*p, err.Error())
+	}
+}
+
+func (p *metadataProblems) addf(format string, args ...any) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// with returns the problems together with those of err.
+func (p metadataProblems) with(err error) error {
+	p.add(err)
+	return p.err()
+}
+
+func (p metadataProblems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return &MetadataError{Problems: p}
+}
+
+type metadataTag struct {
+	key        string
+	required   bool
+	fromEnv    bool
+	fromSecret bool
+	def        string
+	enum       []string
+	env        string
+}
+
+func metadataTagOf(field reflect.StructField) (metadataTag, bool) {
+	value, ok := field.Tag.Lookup("metadata")
+	if !ok || !field.IsExported() {
+		return metadataTag{}, false
+	}
+	options := strings.Split(value, ",")
+	tag := metadataTag{key: options[0], def: field.Tag.Get("default"), env: field.Tag.Get("env")}
+	for _, option := range options[1:] {
+		switch option {
+		case "required":
+			tag.required = true
+		case "fromEnv":
+			tag.fromEnv = true
+		case "fromSecret":
+			tag.fromSecret = true
+		}
+	}
+	if enum := field.Tag.Get("enum"); enum != "" {
+		tag.enum = strings.Split(enum, "|")
+	}
+	return tag, true
+}
+
+// envKeys returns the keys that name an environment variable holding the value.
+func (t metadataTag) envKeys() []string {
+	var keys []string
+	if t.fromEnv {
+		keys = append(keys, t.key+"FromEnv")
+	}
+	if t.env != "" {
+		keys = append(keys, t.env)
+	}
+	return keys
+}
+
+// name is the key reported for a missing value.
+func (t metadataTag) name() string {
+	if keys := t.envKeys(); len(keys) > 0 {
+		return t.key + " or " + keys[len(keys)-1]
+	}
+	return t.key
+}
+
+type metadataField struct {
+	tag   metadataTag
+	value reflect.Value
+}
+
+// metadataFields returns the tagged fields of the struct v, including the fields
+// of embedded structs shared by several backends.
+func metadataFields(v reflect.Value) []metadataField {
+	var fields []metadataField
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, metadataFields(v.Field(i))...)
+			continue
+		}
+		if tag, ok := metadataTagOf(field); ok {
+			fields = append(fields, metadataField{tag: tag, value: v.Field(i)})
+		}
+	}
+	return fields
+}
+
+// decodeMetadata sets the tagged fields of meta, a pointer to a struct, from
+// metadata. Secret references are resolved in namespace. Keys for which extra
+// returns true are handled by the caller.
+func decodeMetadata(metadata map[string]string, namespace string, meta any, extra func(key string) bool) metadataProblems {
+	var problems metadataProblems
+	known := map[string]bool{}
+	for _, field := range metadataFields(reflect.ValueOf(meta).Elem()) {
+		tag := field.tag
+		source := tag.key
+		value, set := metadata[tag.key]
+		known[tag.key] = true
+		if tag.fromSecret {
+			refKey := tag.key + "SecretRef"
+			known[refKey] = true
+			if ref, ok := metadata[refKey]; ok && !set {
+				secret, err := lookupSecretRef(namespace, ref)
+				if err != nil {
+					problems.addf("%s: %v", refKey, err)
+				}
+				value, set, source = secret, true, refKey
+			}
+		}
+		for _, envKey := range tag.envKeys() {
+			known[envKey] = true
+			name, ok := metadata[envKey]
+			if !ok || set {
+				// Values in the metadata take precedence, so a TriggerAuthentication
+				// overrides the environment of the scaler
+				continue
+			}
+			value, set, source = os.Getenv(name), true, envKey
+			if value == "" {
+				problems.addf("%s refers to an empty environment variable", envKey)
+			}
+		}
+		if value == "" && field.value.Kind() != reflect.String {
+			// Empty strings get their default in validateMetadata, after
+			// backends have filled them from other sources
+			value = tag.def
+		}
+		if value == "" {
+			continue
+		}
+		if err := setMetadataField(field.value, value); err != nil {
+			problems.addf("%s %v", source, err)
+		}
+	}
+
+	var unknown []string
+	for key := range metadata {
+		if !known[key] && !slices.Contains(commonMetadataKeys, key) && (extra == nil || !extra(key)) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems.addf("unknown metadata key '%s'", key)
+	}
+	return problems
+}
+
+func setMetadataField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string, Secret:
+		field.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be 'true' or 'false'")
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		field.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return errors.New("must be a non-negative duration such as '30s'")
+		}
+		field.SetInt(int64(d))
+	case []string:
+		field.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("has unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// validateMetadata sets the default of empty string fields and checks required
+// and enum fields of meta, a pointer to a struct.
+func validateMetadata(meta any) metadataProblems {
+	var problems metadataProblems
+	for _, f := range metadataFields(reflect.ValueOf(meta).Elem()) {
+		tag, field := f.tag, f.value
+		if field.Kind() == reflect.String && field.String() == "" && tag.def != "" {
+			field.SetString(tag.def)
+		}
+		if tag.required && (field.Kind() == reflect.String || field.Kind() == reflect.Slice) && field.Len() == 0 {
+			problems.addf("%s is required", tag.name())
+		}
+		if len(tag.enum) > 0 && field.Kind() == reflect.String && field.String() != "" && !slices.Contains(tag.enum, field.String()) {
+			problems.addf("%s must be one of %s", tag.key, strings.Join(tag.enum, ", "))
+		}
+	}
+	return problems
+}
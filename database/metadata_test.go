@@ -0,0 +1,115 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMetadata struct {
+	Name     string        `metadata:"name,required"`
+	Mode     string        `metadata:"mode" default:"fast" enum:"fast|slow"`
+	Enabled  bool          `metadata:"enabled"`
+	Count    int           `metadata:"count" default:"3"`
+	Interval time.Duration `metadata:"interval" default:"1m"`
+	Hosts    []string      `metadata:"hosts"`
+	Token    string        `metadata:"token,fromEnv"`
+	Secret   Secret        `metadata:"secret,required,fromEnv" env:"secretEnv"`
+	Internal string
+}
+
+func TestDecodeMetadata(t *testing.T) {
+	t.Setenv("TEST_TOKEN", "token")
+	t.Setenv("TEST_SECRET", "secret")
+	meta := &testMetadata{}
+	problems := decodeMetadata(map[string]string{
+		"name":         "calendar",
+		"enabled":      "true",
+		"interval":     "0s",
+		"hosts":        "db1, db2,",
+		"tokenFromEnv": "TEST_TOKEN",
+		"secretEnv":    "TEST_SECRET",
+		"type":         "test",
+	}, "", meta, nil)
+	problems = append(problems, validateMetadata(meta)...)
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if meta.Name != "calendar" || meta.Mode != "fast" || !meta.Enabled || meta.Count != 3 || meta.Interval != 0 {
+		t.Errorf("unexpected values %+v", meta)
+	}
+	if !slices.Equal(meta.Hosts, []string{"db1", "db2"}) {
+		t.Errorf("expected hosts [db1 db2], got %v", meta.Hosts)
+	}
+	if meta.Token != "token" || meta.Secret != "secret" {
+		t.Errorf("expected values from the environment, got '%s' and '%s'", meta.Token, meta.Secret)
+	}
+}
+
+func TestDecodeMetadata_ReportsAllProblems(t *testing.T) {
+	t.Setenv("TEST_EMPTY", "")
+	meta := &testMetadata{}
+	problems := decodeMetadata(map[string]string{
+		"mode":         "medium",
+		"enabled":      "yes",
+		"count":        "many",
+		"interval":     "-1s",
+		"tokenFromEnv": "TEST_EMPTY",
+		"nmae":         "calendar",
+		"filters.0":    "handled",
+	}, "", meta, func(key string) bool { return key == "filters.0" })
+	problems = append(problems, validateMetadata(meta)...)
+	expected := []string{
+		"enabled must be 'true' or 'false'",
+		"count must be an integer",
+		"interval must be a non-negative duration such as '30s'",
+		"tokenFromEnv refers to an empty environment variable",
+		"unknown metadata key 'nmae'",
+		"name is required",
+		"mode must be one of fast, slow",
+		"secret or secretEnv is required",
+	}
+	if !slices.Equal([]string(problems), expected) {
+		t.Errorf("expected problems\n%v\ngot\n%v", expected, problems)
+	}
+
+	var metadataErr *MetadataError
+	if err := problems.err(); !errors.As(err, &metadataErr) || len(metadataErr.Problems) != len(expected) {
+		t.Errorf("expected a MetadataError with all problems, got %v", err)
+	}
+}
+
+func TestDecodeMetadata_MetadataOverridesEnv(t *testing.T) {
+	t.Setenv("TEST_SECRET", "from-env")
+	meta := &testMetadata{}
+	problems := decodeMetadata(map[string]string{
+		"name":      "calendar",
+		"secret":    "from-trigger-authentication",
+		"secretEnv": "TEST_SECRET",
+	}, "", meta, nil)
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if meta.Secret.Value() != "from-trigger-authentication" {
+		t.Errorf("expected the metadata value to take precedence, got '%s'", meta.Secret.Value())
+	}
+}
+
+func TestSecret_Redacted(t *testing.T) {
+	meta := testMetadata{Name: "calendar", Secret: "hunter2"}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q"} {
+		if out := fmt.Sprintf(format, meta); strings.Contains(out, "hunter2") {
+			t.Errorf("%s: expected secret to be redacted, got %s", format, out)
+		}
+	}
+	if out := fmt.Sprintf("%v", meta.Secret); out != "[redacted]" {
+		t.Errorf("expected [redacted], got %s", out)
+	}
+	if data, _ := json.Marshal(meta); strings.Contains(string(data), "hunter2") {
+		t.Errorf("expected secret to be redacted in JSON, got %s", data)
+	}
+}
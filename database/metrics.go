@@ -0,0 +1,32 @@
+package database
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics describing the state of the backends. They are registered
+// on the default registry and served by main on /metrics.
+
+var gitRevisionInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "calendar_scaler_git_revision_info",
+	Help: "Commit SHA of the git repository currently being evaluated (value is always 1).",
+}, []string{"repository", "branch", "revision"})
+
+var gitSyncFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "calendar_scaler_git_sync_failures_total",
+	Help: "Number of failed git clone/pull or schedule parse attempts.",
+}, []string{"repository", "branch"})
+
+var postgresQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "calendar_scaler_postgresql_queries_total",
+	Help: "Number of event queries per PostgreSQL host and result (success or error).",
+}, []string{"host", "result"})
+
+var rejectedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "calendar_scaler_rejected_events_total",
+	Help: "Number of events rejected because they could not be parsed or validated.",
+}, []string{"backend", "namespace", "scaledobject"})
+
+func init() {
+	prometheus.MustRegister(gitRevisionInfo, gitSyncFailures, postgresQueries, rejectedEvents)
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "calendar-scaler/externalscaler"
+)
+
+const (
+	msGraphDefaultEndpoint      = "https://graph.microsoft.com/v1.0"
+	msGraphDefaultTokenEndpoint = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+	msGraphScope                = "https://graph.microsoft.com/.default"
+	msGraphDateTimeLayout       = "2006-01-02T15:04:05.9999999"
+)
+
+type MSGraphMetadata struct {
+	TenantID                string `metadata:"tenantId,required"`
+	ClientID                string `metadata:"clientId,required"`
+	ClientSecret            Secret `metadata:"clientSecret,required,fromEnv,fromSecret" env:"clientSecretEnv"`
+	User                    string `metadata:"user,required"`
+	CalendarID              string `metadata:"calendarId"`
+	Endpoint                string `metadata:"endpoint"`
+	TokenEndpoint           string `metadata:"tokenEndpoint"`
+	DesiredReplicasProperty string `metadata:"desiredReplicasProperty"`
+	DesiredReplicasTag      string `metadata:"desiredReplicasTag" default:"desiredReplicas"`
+	TimeZone                string `metadata:"timezone,required"`
+	Namespace               string
+	ScaledObject            string
+}
+
+func NewMSGraphMetadata(scaledObject *pb.ScaledObjectRef) (*MSGraphMetadata, error) {
+	meta := &MSGraphMetadata{
+		Namespace:    scaledObject.GetNamespace(),
+		ScaledObject: scaledObject.GetName(),
+	}
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), meta, nil)
+	problems.add(meta.validate())
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (meta *MSGraphMetadata) validate() error {
+	problems := validateMetadata(meta)
+	if meta.Endpoint == "" {
+		meta.Endpoint = msGraphDefaultEndpoint
+	}
+	if meta.TokenEndpoint == "" {
+		meta.TokenEndpoint = fmt.Sprintf(msGraphDefaultTokenEndpoint, url.PathEscape(meta.TenantID))
+	}
+	return problems.err()
+}
+
+type MSGraphClient struct {
+	Meta *MSGraphMetadata
+}
+
+func NewMSGraph(meta *MSGraphMetadata) (*MSGraphClient, error) {
+	return &MSGraphClient{Meta: meta}, nil
+}
+
+func (c *MSGraphClient) token(ctx context.Context) (string, error) {
+	// The key includes the client secret, so a trigger with the same client ID
+	// but without the secret cannot get the cached token
+	key := "msgraph/" + c.Meta.TokenEndpoint + "/" + c.Meta.ClientID + "/" + c.Meta.ClientSecret.digest()
+	return getAccessToken(key, func() (accessToken, error) {
+		return requestAccessToken(ctx, c.Meta.TokenEndpoint, url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {c.Meta.ClientID},
+			"client_secret": {c.Meta.ClientSecret.Value()},
+			"scope":         {msGraphScope},
+		})
+	})
+}
+
+type msGraphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type msGraphEvent struct {
+	ID          string          `json:"id"`
+	IsCancelled bool            `json:"isCancelled"`
+	Start       msGraphDateTime `json:"start"`
+	End         msGraphDateTime `json:"end"`
+	Body        struct {
+		Content string `json:"content"`
+	} `json:"body"`
+	SingleValueExtendedProperties []struct {
+		ID    string `json:"id"`
+		Value string `json:"value"`
+	} `json:"singleValueExtendedProperties"`
+}
+
+type msGraphEventList struct {
+	Value    []msGraphEvent `json:"value"`
+	NextLink string         `json:"@odata.nextLink"`
+}
+
+func (c *MSGraphClient) calendarViewURL(now time.Time) string {
+	path := "/users/" + url.PathEscape(c.Meta.User) + "/calendar/calendarView"
+	if c.Meta.CalendarID != "" {
+		path = "/users/" + url.PathEscape(c.Meta.User) + "/calendars/" + url.PathEscape(c.Meta.CalendarID) + "/calendarView"
+	}
+	query := url.Values{
+		"startDateTime": {now.UTC().Format(time.RFC3339)},
+		"endDateTime":   {now.Add(time.Second).UTC().Format(time.RFC3339)},
+	}
+	if c.Meta.DesiredReplicasProperty != "" {
+		query.Set("$expand", fmt.Sprintf("singleValueExtendedProperties($filter=id eq '%s')", c.Meta.DesiredReplicasProperty))
+	}
+	return strings.TrimRight(c.Meta.Endpoint, "/") + path + "?" + query.Encode()
+}
+
+func (c *MSGraphClient) GetEvents() ([]Event, error) {
+	location, err := time.LoadLocation(c.Meta.TimeZone)
+	if err != nil {
+		fmt.Printf("[MSGraph Error] failed to load timezone '%s': %v\n", c.Meta.TimeZone, err)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), calendarAPITimeout)
+	defer cancel()
+	token, err := c.token(ctx)
+	if err != nil {
+		fmt.Printf("[MSGraph Error] failed to get access token: %v\n", err)
+		return nil, err
+	}
+	headers := map[string]string{"Prefer": `outlook.timezone="UTC"`}
+	requestURL := c.calendarViewURL(time.Now().In(location))
+	var events []Event
+	for requestURL != "" {
+		var list msGraphEventList
+		if err := getJSON(ctx, requestURL, token, headers, &list); err != nil {
+			fmt.Printf("[MSGraph Error] failed to list events of '%s': %v\n", c.Meta.User, err)
+			return nil, err
+		}
+		for _, item := range list.Value {
+			if item.IsCancelled {
+				continue
+			}
+			event, err := item.toEvent(c.Meta)
+			if err != nil {
+				fmt.Printf("[MSGraph Parse Error] event '%s': %v\n", item.ID, err)
+				continue
+			}
+			events = append(events, event)
+		}
+		requestURL = list.NextLink
+	}
+	return events, nil
+}
+
+func (item *msGraphEvent) toEvent(meta *MSGraphMetadata) (Event, error) {
+	start, err := item.Start.parse()
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to parse start: %w", err)
+	}
+	end, err := item.End.parse()
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to parse end: %w", err)
+	}
+	for _, prop := range item.SingleValueExtendedProperties {
+		if meta.DesiredReplicasProperty != "" && strings.EqualFold(prop.ID, meta.DesiredReplicasProperty) {
+			desiredReplicas, err := strconv.Atoi(strings.TrimSpace(prop.Value))
+			if err != nil {
+				return Event{}, fmt.Errorf("invalid extended property '%s': %w", prop.ID, err)
+			}
+			return Event{StartTime: start, EndTime: end, DesiredReplicas: desiredReplicas}, nil
+		}
+	}
+	desiredReplicas, ok := parseReplicasTag(item.Body.Content, meta.DesiredReplicasTag)
+	if !ok {
+		return Event{}, fmt.Errorf("no extended property or '%s' description tag", meta.DesiredReplicasTag)
+	}
+	return Event{StartTime: start, EndTime: end, DesiredReplicas: desiredReplicas}, nil
+}
+
+// parse interprets Graph's zone-less dateTime in the accompanying timeZone.
+func (t msGraphDateTime) parse() (time.Time, error) {
+	location := time.UTC
+	if t.TimeZone != "" {
+		loc, err := time.LoadLocation(t.TimeZone)
+		if err != nil {
+			return time.Time{}, err
+		}
+		location = loc
+	}
+	return time.ParseInLocation(msGraphDateTimeLayout, t.DateTime, location)
+}
+
+func (c *MSGraphClient) Close() error {
+	// No explicit Close required for Microsoft Graph
+	return nil
+}
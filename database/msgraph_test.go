@@ -0,0 +1,118 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestNewMSGraphMetadata_RequiredFields(t *testing.T) {
+	scaledObject := &pb.ScaledObjectRef{
+		ScalerMetadata: map[string]string{
+			"tenantId":        "tenant",
+			"clientId":        "client",
+			"clientSecretEnv": "GRAPH_CLIENT_SECRET",
+			"user":            "rooms@example.com",
+			"timezone":        "UTC",
+		},
+	}
+	if _, err := NewMSGraphMetadata(scaledObject); err == nil {
+		t.Error("expected error for empty client secret")
+	}
+	os.Setenv("GRAPH_CLIENT_SECRET", "secret")
+	t.Cleanup(func() {
+		os.Unsetenv("GRAPH_CLIENT_SECRET")
+	})
+	meta, err := NewMSGraphMetadata(scaledObject)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.TokenEndpoint != "https://login.microsoftonline.com/tenant/oauth2/v2.0/token" {
+		t.Errorf("unexpected default token endpoint '%s'", meta.TokenEndpoint)
+	}
+}
+
+func TestMSGraphClient_GetEvents(t *testing.T) {
+	property := "String {00020329-0000-0000-C000-000000000046} Name desiredReplicas"
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_secret") != "secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token":"graph-token","expires_in":3600}`))
+	})
+	mux.HandleFunc("/users/rooms@example.com/calendar/calendarView", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer graph-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("$expand") == "" {
+			http.Error(w, "missing $expand", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"value":[
+			{"id":"a","start":{"dateTime":"2024-06-11T03:00:00.0000000","timeZone":"UTC"},"end":{"dateTime":"2024-06-11T04:00:00.0000000","timeZone":"UTC"},
+			 "singleValueExtendedProperties":[{"id":"` + property + `","value":"5"}]},
+			{"id":"b","start":{"dateTime":"2024-06-11T03:00:00.0000000","timeZone":"UTC"},"end":{"dateTime":"2024-06-11T04:00:00.0000000","timeZone":"UTC"},
+			 "body":{"content":"<p>desiredReplicas: 3</p>"}},
+			{"id":"c","isCancelled":true,"start":{"dateTime":"2024-06-11T03:00:00.0000000","timeZone":"UTC"},"end":{"dateTime":"2024-06-11T04:00:00.0000000","timeZone":"UTC"},
+			 "body":{"content":"desiredReplicas: 7"}}
+		]}`))
+	})
+
+	client, err := NewMSGraph(&MSGraphMetadata{
+		TenantID:                "tenant",
+		ClientID:                "client",
+		ClientSecret:            "secret",
+		User:                    "rooms@example.com",
+		Endpoint:                server.URL,
+		TokenEndpoint:           server.URL + "/token",
+		DesiredReplicasProperty: property,
+		DesiredReplicasTag:      "desiredReplicas",
+		TimeZone:                "UTC",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events, err := client.GetEvents()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].DesiredReplicas != 5 || events[1].DesiredReplicas != 3 {
+		t.Errorf("unexpected desired replicas: %+v", events)
+	}
+	if events[0].StartTime.Hour() != 3 {
+		t.Errorf("expected start at 03:00 UTC, got %s", events[0].StartTime)
+	}
+}
+
+func TestMSGraphClient_TokenCacheRequiresSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "secret" {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"access_token":"victim-token","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	meta := MSGraphMetadata{ClientID: "shared-client", ClientSecret: "secret", TokenEndpoint: server.URL}
+	victim, _ := NewMSGraph(&meta)
+	if token, err := victim.token(context.Background()); err != nil || token != "victim-token" {
+		t.Fatalf("expected victim-token, got '%s' (err=%v)", token, err)
+	}
+	meta.ClientSecret = "guessed"
+	other, _ := NewMSGraph(&meta)
+	if token, err := other.token(context.Background()); err == nil {
+		t.Errorf("expected a wrong client secret to miss the token cache, got '%s'", token)
+	}
+}
@@ -3,130 +3,170 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 
 	"errors"
-	"os"
-	"reflect"
+	"strings"
 
 	pb "calendar-scaler/externalscaler"
 )
 
 type PostgreSQLMetadata struct {
-	Host     string `validate:"optional" default:"localhost"`
-	Port     string `validate:"optional" default:"5432"`
-	User     string `validate:"required"`
-	Password string `validate:"required"`
-	Database string `validate:"required"`
-	Table    string `validate:"required"`
-	TimeZone string `validate:"required"`
-
-	DesiredReplicasColumn string `validate:"required"`
-	StartTimeColumn       string `validate:"required"`
-	EndTimeColumn         string `validate:"required"`
-	TargetColumn          string `validate:"optional"`
-
-	Namespace    string `validate:"optional"`
-	ScaledObject string `validate:"optional"`
+	Host     string `metadata:"host" default:"localhost"`
+	Port     string `metadata:"port" default:"5432"`
+	User     string `metadata:"username,required"`
+	Password Secret `metadata:"password,fromEnv,fromSecret" env:"passwordEnv"`
+	Database string `metadata:"database,required"`
+	Table    string `metadata:"table,required"`
+	TimeZone string `metadata:"timezone,required"`
+
+	// AuthMode is password (default) or iam, which authenticates with RDS IAM
+	// auth tokens for Region instead of Password.
+	AuthMode string `metadata:"authMode" default:"password" enum:"password|iam"`
+	Region   string `metadata:"region"`
+
+	// ColumnTimeType is auto, timestamptz or timestamp. Naive timestamps are
+	// interpreted in TimeZone.
+	ColumnTimeType string `metadata:"columnTimeType" default:"auto" enum:"auto|timestamptz|timestamp"`
+
+	// Connection is a full DSN (postgres:// URL or key=value). Its values are used
+	// for every connection option not set explicitly in the trigger metadata.
+	Connection         Secret `metadata:"connection,fromEnv,fromSecret"`
+	TargetSessionAttrs string `metadata:"targetSessionAttrs" default:"any" enum:"any|read-write|read-only|primary|standby|prefer-standby"`
+	ConnectionParams   map[string]string
+
+	SSLMode        string `metadata:"sslmode" default:"disable" enum:"disable|require|verify-ca|verify-full"`
+	SSLRootCert    string `metadata:"sslrootcert"`
+	SSLCert        string `metadata:"sslcert"`
+	SSLKey         string `metadata:"sslkey"`
+	SSLRootCertPEM Secret `metadata:"sslrootcertPEM,fromEnv,fromSecret" env:"sslrootcertEnv"`
+	SSLCertPEM     Secret `metadata:"sslcertPEM,fromEnv,fromSecret" env:"sslcertEnv"`
+	SSLKeyPEM      Secret `metadata:"sslkeyPEM,fromEnv,fromSecret" env:"sslkeyEnv"`
+
+	DesiredReplicasColumn string `metadata:"desiredReplicasColumn,required"`
+	StartTimeColumn       string `metadata:"startColumn"`
+	EndTimeColumn         string `metadata:"endColumn"`
+	RangeColumn           string `metadata:"rangeColumn"`
+	TargetColumn          string `metadata:"targetColumn"`
+	TargetColumnType      string `metadata:"targetColumnType" default:"text" enum:"text|array|jsonb"`
+	Schema                string `metadata:"schema"`
+	Filters               []PostgreSQLFilter
+	CronColumn            string `metadata:"cronColumn"`
+	DurationColumn        string `metadata:"durationColumn"`
+	NotifyChannel         string `metadata:"notifyChannel"`
+
+	Namespace    string
+	ScaledObject string
 }
 
 func NewPostgreSQLMetadata(scaledObject *pb.ScaledObjectRef) (*PostgreSQLMetadata, error) {
 	scalerMetadata := &PostgreSQLMetadata{
-		Host:                  scaledObject.GetScalerMetadata()["host"],
-		Port:                  scaledObject.GetScalerMetadata()["port"],
-		User:                  scaledObject.GetScalerMetadata()["username"],
-		Password:              os.Getenv(scaledObject.GetScalerMetadata()["passwordEnv"]),
-		Database:              scaledObject.GetScalerMetadata()["database"],
-		Table:                 scaledObject.GetScalerMetadata()["table"],
-		TimeZone:              scaledObject.GetScalerMetadata()["timezone"],
-		DesiredReplicasColumn: scaledObject.GetScalerMetadata()["desiredReplicasColumn"],
-		StartTimeColumn:       scaledObject.GetScalerMetadata()["startColumn"],
-		EndTimeColumn:         scaledObject.GetScalerMetadata()["endColumn"],
-		TargetColumn:          scaledObject.GetScalerMetadata()["targetColumn"],
-		Namespace:             scaledObject.GetNamespace(),
-		ScaledObject:          scaledObject.GetName(),
-	}
-	if err := scalerMetadata.ValidateAndSetDefaults(scalerMetadata); err != nil {
+		Namespace:    scaledObject.GetNamespace(),
+		ScaledObject: scaledObject.GetName(),
+	}
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), scalerMetadata, func(key string) bool {
+		return strings.HasPrefix(key, "filters.")
+	})
+	problems.add(scalerMetadata.applyConnection())
+	problems.add(scalerMetadata.validateAuthMode())
+	problems.add(scalerMetadata.ValidateAndSetDefaults(scalerMetadata))
+	problems.add(scalerMetadata.validateTimeColumns())
+	filters, err := parsePostgreSQLFilters(scaledObject.GetScalerMetadata())
+	problems.add(err)
+	scalerMetadata.Filters = filters
+	if (scalerMetadata.CronColumn == "") != (scalerMetadata.DurationColumn == "") {
+		problems.addf("cronColumn and durationColumn must be set together")
+	}
+	if len(problems) == 0 {
+		// Identifiers can only be quoted once all of them are present
+		_, err := scalerMetadata.quotedIdentifiers()
+		problems.add(err)
+	}
+	if scalerMetadata.NotifyChannel != "" {
+		channel, err := notifyChannelName(scalerMetadata.NotifyChannel)
+		problems.add(err)
+		scalerMetadata.NotifyChannel = channel
+	}
+	problems.add(scalerMetadata.validateTLS())
+	problems.add(scalerMetadata.validateHosts())
+	if err := problems.err(); err != nil {
 		return nil, err
 	}
 	return scalerMetadata, nil
 }
 
-func (*PostgreSQLMetadata) ValidateAndSetDefaults(metadata interface{}) error {
-	v := reflect.ValueOf(metadata).Elem()
-	t := v.Type()
-
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		tag := t.Field(i).Tag
-
-		if tag.Get("validate") == "required" && field.String() == "" {
-			return errors.New(t.Field(i).Name + " is required")
-		}
-
-		if field.String() == "" {
-			defaultValue := tag.Get("default")
-			if defaultValue != "" {
-				field.SetString(defaultValue)
-			}
+// validateTimeColumns checks that the event window is either a range column or
+// a pair of start and end columns.
+func (m *PostgreSQLMetadata) validateTimeColumns() error {
+	if m.RangeColumn != "" {
+		if m.StartTimeColumn != "" || m.EndTimeColumn != "" {
+			return errors.New("rangeColumn cannot be combined with startColumn and endColumn")
 		}
+		return nil
+	}
+	if m.StartTimeColumn == "" {
+		return errors.New("startColumn is required")
+	}
+	if m.EndTimeColumn == "" {
+		return errors.New("endColumn is required")
 	}
 	return nil
 }
 
-func (m *PostgreSQLMetadata) GetConnectionString() string {
-	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		m.Host,
-		m.Port,
-		m.User,
-		m.Password,
-		m.Database,
-	)
+// ValidateAndSetDefaults applies the defaults and checks the required fields of
+// metadata.
+func (*PostgreSQLMetadata) ValidateAndSetDefaults(metadata interface{}) error {
+	return validateMetadata(metadata).err()
 }
 
 type PostgresDB struct {
-	Conn *sql.DB
-	Meta *PostgreSQLMetadata
+	Conn  *sql.DB
+	Meta  *PostgreSQLMetadata
+	ident *pgIdentifiers
+	host  pgHost
+
+	// timeType is the type of the time columns (timestamp or timestamptz), or
+	// empty if it could not be detected
+	timeType string
 }
 
 func NewPostgresDB(metadata *PostgreSQLMetadata) (*PostgresDB, error) {
-	connStr := metadata.GetConnectionString()
-	conn, err := sql.Open("postgres", connStr)
+	ident, err := metadata.quotedIdentifiers()
 	if err != nil {
 		return nil, err
 	}
-	if err := conn.Ping(); err != nil {
+	conn, host, err := metadata.connect()
+	if err != nil {
 		return nil, err
 	}
-	return &PostgresDB{Conn: conn, Meta: metadata}, nil
-}
-
-func (db *PostgresDB) GetEvents() ([]Event, error) {
-	if db.Meta.TargetColumn == "" {
-		return db.getEventsNoTargetColumn()
-	} else {
-		return db.getEventsWithTargetColumn()
+	db := &PostgresDB{Conn: conn, Meta: metadata, ident: ident, host: host}
+	if db.timeType, err = db.detectTimeType(); err != nil {
+		conn.Close()
+		return nil, err
 	}
+	return db, nil
 }
 
-func (db *PostgresDB) getEventsNoTargetColumn() ([]Event, error) {
+// GetEvents returns the rows active now. Target matching and filters are part of
+// the query, so only the rows of this scaledobject are transferred.
+func (db *PostgresDB) GetEvents() ([]Event, error) {
 	location, err := time.LoadLocation(db.Meta.TimeZone)
 	if err != nil {
 		fmt.Printf("[PostgreSQL Error] failed to load timezone '%s': %v\n", db.Meta.TimeZone, err)
 		return nil, err
 	}
 	now := time.Now().In(location)
+	q := &pgQuery{}
 	query := fmt.Sprintf(
-		"SELECT %s, %s, %s FROM %s WHERE %s <= $1 AND $1 <= %s",
-		db.Meta.StartTimeColumn, db.Meta.EndTimeColumn, db.Meta.DesiredReplicasColumn,
-		db.Meta.Table,
-		db.Meta.StartTimeColumn, db.Meta.EndTimeColumn,
+		"SELECT %s, %s%s FROM %s WHERE %s",
+		db.timeSelect(), db.ident.DesiredReplicas, db.cronSelect(),
+		db.ident.Table,
+		db.whereClause(q, now),
 	)
-	args := []interface{}{now}
-	rows, err := db.Conn.Query(query, args...)
+	rows, err := db.query(query, q.args...)
 	if err != nil {
 		fmt.Printf("[PostgreSQL Error] failed to execute query: %v\n", err)
 		return nil, err
@@ -134,17 +174,23 @@ func (db *PostgresDB) getEventsNoTargetColumn() ([]Event, error) {
 	defer rows.Close()
 	var events []Event
 	for rows.Next() {
-		var start time.Time
-		var end time.Time
-		var desiredReplicas int
-		if err := rows.Scan(&start, &end, &desiredReplicas); err != nil {
+		row := eventRow{Ranged: db.Meta.RangeColumn != ""}
+		if err := rows.Scan(row.dest(db.Meta.CronColumn != "")...); err != nil {
+			fmt.Printf("[PostgreSQL Error] failed to scan row: %v\n", err)
 			return nil, err
 		}
-		events = append(events, Event{
-			StartTime:       start,
-			EndTime:         end,
-			DesiredReplicas: desiredReplicas,
-		})
+		if db.timeType == "timestamp" {
+			row.Start.Time = inLocation(row.Start.Time, location)
+			row.End.Time = inLocation(row.End.Time, location)
+		}
+		event, ok, err := row.event(now)
+		if err != nil {
+			fmt.Printf("[PostgreSQL Parse Error] %v\n", err)
+			continue
+		}
+		if ok {
+			events = append(events, event)
+		}
 	}
 	if err := rows.Err(); err != nil {
 		fmt.Printf("[PostgreSQL Error] error loading events: %v\n", err)
@@ -153,58 +199,89 @@ func (db *PostgresDB) getEventsNoTargetColumn() ([]Event, error) {
 	return events, nil
 }
 
-func (db *PostgresDB) getEventsWithTargetColumn() ([]Event, error) {
-	location, err := time.LoadLocation(db.Meta.TimeZone)
-	if err != nil {
-		fmt.Printf("[PostgreSQL Error] failed to load timezone '%s': %v\n", db.Meta.TimeZone, err)
-		return nil, err
-	}
-	now := time.Now().In(location)
-	query := fmt.Sprintf(
-		"SELECT %s, %s, %s, %s FROM %s WHERE %s <= $1 AND $1 <= %s",
-		db.Meta.StartTimeColumn, db.Meta.EndTimeColumn, db.Meta.DesiredReplicasColumn, db.Meta.TargetColumn,
-		db.Meta.Table,
-		db.Meta.StartTimeColumn, db.Meta.EndTimeColumn,
-	)
-	args := []interface{}{now}
+// query runs the events query. When the connection to the server is lost and
+// there are other hosts, the query is retried once on another host.
+func (db *PostgresDB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	hosts, _ := db.Meta.hosts()
 	rows, err := db.Conn.Query(query, args...)
+	if err != nil && len(hosts) > 1 && isConnectionError(err) {
+		postgresQueries.WithLabelValues(db.host.String(), "error").Inc()
+		fmt.Printf("[PostgreSQL Error] query on %s failed, retrying on another host: %v\n", db.host, err)
+		conn, host, connectErr := db.Meta.connect(db.host)
+		if connectErr != nil {
+			return nil, errors.Join(err, connectErr)
+		}
+		db.Conn.Close()
+		db.Conn, db.host = conn, host
+		rows, err = db.Conn.Query(query, args...)
+	}
 	if err != nil {
-		fmt.Printf("[PostgreSQL Error] failed to execute query: %v\n", err)
+		postgresQueries.WithLabelValues(db.host.String(), "error").Inc()
 		return nil, err
 	}
-	defer rows.Close()
-	var events []Event
-	targetKey := db.Meta.Namespace + "/" + db.Meta.ScaledObject
-	for rows.Next() {
-		var start time.Time
-		var end time.Time
-		var desiredReplicas int
-		var targets string
-		if err := rows.Scan(&start, &end, &desiredReplicas, &targets); err != nil {
-			fmt.Printf("[PostgreSQL Error] failed to scan row: %v\n", err)
-			return nil, err
-		}
-		found := false
-		for _, t := range strings.Split(targets, ",") {
-			if strings.TrimSpace(t) == targetKey {
-				found = true
-				break
-			}
+	postgresQueries.WithLabelValues(db.host.String(), "success").Inc()
+	if len(hosts) > 1 {
+		key := fmt.Sprintf("%s/%s|%s|%s|%s", db.Meta.Namespace, db.Meta.ScaledObject, db.Meta.Host, db.Meta.Port, db.Meta.Table)
+		if previous, changed := servingHostChanged(key, db.host); changed && previous != (pgHost{}) {
+			fmt.Printf("[PostgreSQL] events of %s/%s served by %s instead of %s\n", db.Meta.Namespace, db.Meta.ScaledObject, db.host, previous)
+		} else if changed {
+			fmt.Printf("[PostgreSQL] events of %s/%s served by %s\n", db.Meta.Namespace, db.Meta.ScaledObject, db.host)
 		}
-		if !found {
-			continue
-		}
-		events = append(events, Event{
-			StartTime:       start,
-			EndTime:         end,
-			DesiredReplicas: desiredReplicas,
-		})
 	}
-	if err := rows.Err(); err != nil {
-		fmt.Printf("[PostgreSQL Error] error loading events: %v\n", err)
-		return nil, err
+	return rows, nil
+}
+
+// pgServingHosts holds the host that last served the queries of each trigger.
+// A backend only lives for one request, so the host is kept here to log a
+// failover once instead of the host on every query.
+var pgServingHosts = struct {
+	sync.Mutex
+	m map[string]pgHost
+}{m: map[string]pgHost{}}
+
+// servingHostChanged records host as the serving host of key and returns the
+// previous one if it was different.
+func servingHostChanged(key string, host pgHost) (pgHost, bool) {
+	pgServingHosts.Lock()
+	defer pgServingHosts.Unlock()
+	previous, ok := pgServingHosts.m[key]
+	pgServingHosts.m[key] = host
+	return previous, !ok || previous != host
+}
+
+// eventRow is a scanned calendar row. Start and end are NULL for recurring rows,
+// and for unbounded or infinite sides of a range.
+type eventRow struct {
+	Start           sql.NullTime
+	End             sql.NullTime
+	DesiredReplicas int
+	Cron            sql.NullString
+	Duration        sql.NullString
+	Ranged          bool
+}
+
+func (row *eventRow) dest(withCron bool) []interface{} {
+	dest := []interface{}{&row.Start, &row.End, &row.DesiredReplicas}
+	if withCron {
+		dest = append(dest, &row.Cron, &row.Duration)
 	}
-	return events, nil
+	return dest
+}
+
+func (row *eventRow) event(now time.Time) (Event, bool, error) {
+	if row.Cron.Valid && row.Cron.String != "" {
+		return cronEvent(row.Cron.String, row.Duration.String, row.DesiredReplicas, now)
+	}
+	if !row.Ranged && (!row.Start.Valid || !row.End.Valid) {
+		return Event{}, false, fmt.Errorf("row without start/end or cron expression")
+	}
+	// Rows with start/end or a range were already matched by the query.
+	// Unbounded sides of a range are left as the zero time.
+	return Event{
+		StartTime:       row.Start.Time,
+		EndTime:         row.End.Time,
+		DesiredReplicas: row.DesiredReplicas,
+	}, true, nil
 }
 
 func (db *PostgresDB) Close() error {
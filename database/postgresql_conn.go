@@ -0,0 +1,453 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// validateTLS checks the certificate options against each other and sslmode.
+// Certificates are given either as paths of mounted files or as PEM content read
+// from environment variables. When any PEM content is used, the file based
+// certificates are loaded as well so that the driver can receive all of them
+// inline.
+func (m *PostgreSQLMetadata) validateTLS() error {
+	var problems metadataProblems
+	// pemKeys are the metadata keys the PEM content can be given with
+	certs := []struct {
+		name    string
+		pemKeys string
+		path    *string
+		pem     *Secret
+	}{
+		{"sslrootcert", "sslrootcertPEM (or sslrootcertPEMFromEnv, sslrootcertPEMSecretRef, sslrootcertEnv)", &m.SSLRootCert, &m.SSLRootCertPEM},
+		{"sslcert", "sslcertPEM (or sslcertPEMFromEnv, sslcertPEMSecretRef, sslcertEnv)", &m.SSLCert, &m.SSLCertPEM},
+		{"sslkey", "sslkeyPEM (or sslkeyPEMFromEnv, sslkeyPEMSecretRef, sslkeyEnv)", &m.SSLKey, &m.SSLKeyPEM},
+	}
+	inline := false
+	for _, cert := range certs {
+		if *cert.path != "" && *cert.pem != "" {
+			problems.addf("%s and %s cannot be set together", cert.name, cert.pemKeys)
+			continue
+		}
+		if *cert.pem != "" {
+			if block, _ := pem.Decode([]byte(cert.pem.Value())); block == nil {
+				problems.addf("%s does not contain PEM data", cert.pemKeys)
+			}
+			inline = true
+		}
+		if *cert.path != "" {
+			if _, err := os.Stat(*cert.path); err != nil {
+				problems.addf("%s: %v", cert.name, err)
+			}
+		}
+	}
+	if (m.SSLCert == "" && m.SSLCertPEM == "") != (m.SSLKey == "" && m.SSLKeyPEM == "") {
+		problems.addf("sslcert and sslkey must be set together")
+	}
+	if m.SSLMode == "disable" && (m.SSLRootCert != "" || m.SSLCert != "" || inline) {
+		problems.addf("certificates require sslmode to be require, verify-ca or verify-full")
+	}
+	if len(problems) > 0 {
+		return problems.err()
+	}
+	if inline {
+		for _, cert := range certs {
+			if *cert.path == "" {
+				continue
+			}
+			data, err := os.ReadFile(*cert.path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", cert.name, err)
+			}
+			*cert.pem = Secret(data)
+			*cert.path = ""
+		}
+	}
+	return nil
+}
+
+// pgHost is one of the (comma-separated) hosts of a trigger.
+type pgHost struct {
+	Host string
+	Port string
+}
+
+func (h pgHost) String() string {
+	return net.JoinHostPort(h.Host, h.Port)
+}
+
+// hosts pairs the comma-separated hosts and ports. A single port applies to every host.
+func (m *PostgreSQLMetadata) hosts() ([]pgHost, error) {
+	hosts := strings.Split(m.Host, ",")
+	ports := strings.Split(m.Port, ",")
+	if len(ports) != 1 && len(ports) != len(hosts) {
+		return nil, fmt.Errorf("port must be a single port or one port per host")
+	}
+	result := make([]pgHost, 0, len(hosts))
+	for i, host := range hosts {
+		port := ports[0]
+		if len(ports) > 1 {
+			port = ports[i]
+		}
+		host, port = strings.TrimSpace(host), strings.TrimSpace(port)
+		if host == "" {
+			host = "localhost"
+		}
+		if port == "" {
+			port = "5432"
+		}
+		result = append(result, pgHost{Host: host, Port: port})
+	}
+	return result, nil
+}
+
+func (m *PostgreSQLMetadata) validateHosts() error {
+	_, err := m.hosts()
+	return err
+}
+
+// applyConnection fills the connection options that are not set in the trigger
+// metadata from the Connection DSN. Options the scaler does not handle itself
+// (application_name, connect_timeout, ...) are passed on to the driver.
+func (m *PostgreSQLMetadata) applyConnection() error {
+	if m.Connection == "" {
+		return nil
+	}
+	params, err := parseDSN(m.Connection.Value())
+	if err != nil {
+		return fmt.Errorf("invalid connection: %w", err)
+	}
+	if params["sslinline"] == "true" {
+		params["sslrootcertPEM"], params["sslcertPEM"], params["sslkeyPEM"] = params["sslrootcert"], params["sslcert"], params["sslkey"]
+		delete(params, "sslrootcert")
+		delete(params, "sslcert")
+		delete(params, "sslkey")
+	}
+	delete(params, "sslinline")
+	fields := []struct {
+		key  string
+		dest *string
+	}{
+		{"host", &m.Host},
+		{"port", &m.Port},
+		{"user", &m.User},
+		{"dbname", &m.Database},
+		{"sslmode", &m.SSLMode},
+		{"sslrootcert", &m.SSLRootCert},
+		{"sslcert", &m.SSLCert},
+		{"sslkey", &m.SSLKey},
+		{"target_session_attrs", &m.TargetSessionAttrs},
+	}
+	for _, field := range fields {
+		if *field.dest == "" {
+			*field.dest = params[field.key]
+		}
+		delete(params, field.key)
+	}
+	secrets := []struct {
+		key  string
+		dest *Secret
+	}{
+		{"password", &m.Password},
+		{"sslrootcertPEM", &m.SSLRootCertPEM},
+		{"sslcertPEM", &m.SSLCertPEM},
+		{"sslkeyPEM", &m.SSLKeyPEM},
+	}
+	for _, secret := range secrets {
+		if *secret.dest == "" {
+			*secret.dest = Secret(params[secret.key])
+		}
+		delete(params, secret.key)
+	}
+	m.ConnectionParams = params
+	return nil
+}
+
+// GetConnectionString returns the connection string of the first host.
+func (m *PostgreSQLMetadata) GetConnectionString() string {
+	hosts, err := m.hosts()
+	if err != nil || len(hosts) == 0 {
+		hosts = []pgHost{{Host: m.Host, Port: m.Port}}
+	}
+	return m.connectionString(hosts[0])
+}
+
+func (m *PostgreSQLMetadata) connectionString(host pgHost) string {
+	params := map[string]string{}
+	for key, value := range m.ConnectionParams {
+		params[key] = value
+	}
+	params["host"] = host.Host
+	params["port"] = host.Port
+	params["user"] = m.User
+	params["password"] = m.Password.Value()
+	params["dbname"] = m.Database
+	params["sslmode"] = m.SSLMode
+	if m.SSLRootCertPEM != "" || m.SSLCertPEM != "" || m.SSLKeyPEM != "" {
+		params["sslinline"] = "true"
+		params["sslrootcert"] = m.SSLRootCertPEM.Value()
+		params["sslcert"] = m.SSLCertPEM.Value()
+		params["sslkey"] = m.SSLKeyPEM.Value()
+	} else {
+		params["sslrootcert"] = m.SSLRootCert
+		params["sslcert"] = m.SSLCert
+		params["sslkey"] = m.SSLKey
+	}
+	return formatDSN(params)
+}
+
+// connect tries the hosts in order and returns the first connection that
+// satisfies target_session_attrs, together with the host it was made to. Hosts in
+// exclude are only tried when no other host is suitable. With prefer-standby,
+// standby servers are tried first and any reachable server is accepted after that.
+func (m *PostgreSQLMetadata) connect(exclude ...pgHost) (*sql.DB, pgHost, error) {
+	hosts, err := m.hosts()
+	if err != nil {
+		return nil, pgHost{}, err
+	}
+	if len(exclude) > 0 {
+		var preferred, excluded []pgHost
+		for _, host := range hosts {
+			if containsHost(exclude, host) {
+				excluded = append(excluded, host)
+			} else {
+				preferred = append(preferred, host)
+			}
+		}
+		hosts = append(preferred, excluded...)
+	}
+	passes := []string{m.TargetSessionAttrs}
+	if m.TargetSessionAttrs == "prefer-standby" {
+		passes = []string{"standby", "any"}
+	}
+	var errs []error
+	unreachable := map[pgHost]bool{}
+	for _, attrs := range passes {
+		for _, host := range hosts {
+			if unreachable[host] {
+				continue
+			}
+			conn, err := m.open(host)
+			if err == nil {
+				if err = conn.Ping(); err == nil {
+					err = checkTargetSessionAttrs(conn, attrs)
+				} else {
+					unreachable[host] = true
+				}
+				if err == nil {
+					return conn, host, nil
+				}
+				conn.Close()
+			} else {
+				unreachable[host] = true
+			}
+			if len(hosts) > 1 {
+				fmt.Printf("[PostgreSQL Error] failed to connect to %s: %v\n", host, err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		}
+	}
+	return nil, pgHost{}, errors.Join(errs...)
+}
+
+func containsHost(hosts []pgHost, host pgHost) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTargetSessionAttrs checks a connection against target_session_attrs.
+// prefer-standby is resolved by connect.
+func checkTargetSessionAttrs(conn *sql.DB, attrs string) error {
+	switch attrs {
+	case "read-write", "read-only":
+		var readOnly string
+		if err := conn.QueryRow("SHOW transaction_read_only").Scan(&readOnly); err != nil {
+			return err
+		}
+		if attrs == "read-write" && readOnly == "on" {
+			return fmt.Errorf("server is read-only")
+		}
+		if attrs == "read-only" && readOnly != "on" {
+			return fmt.Errorf("server is not read-only")
+		}
+	case "primary", "standby":
+		var inRecovery bool
+		if err := conn.QueryRow("SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+			return err
+		}
+		if attrs == "primary" && inRecovery {
+			return fmt.Errorf("server is a standby")
+		}
+		if attrs == "standby" && !inRecovery {
+			return fmt.Errorf("server is not a standby")
+		}
+	}
+	return nil
+}
+
+// isConnectionError reports whether err means the server or the connection to it
+// is gone, so that the query can be retried on another host.
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// connection_exception, admin_shutdown, crash_shutdown, cannot_connect_now
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return false
+}
+
+// parseDSN parses a postgres:// URL or a key=value connection string.
+// Errors never contain the DSN itself, since it usually holds a password.
+func parseDSN(dsn string) (map[string]string, error) {
+	dsn = strings.TrimSpace(dsn)
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return parseDSNURL(dsn)
+	}
+	return parseDSNKeyValue(dsn)
+}
+
+func parseDSNURL(dsn string) (map[string]string, error) {
+	params := map[string]string{}
+	_, rest, _ := strings.Cut(dsn, "://")
+	rest, rawQuery, _ := strings.Cut(rest, "?")
+	authority, path, _ := strings.Cut(rest, "/")
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userinfo := authority[:i]
+		authority = authority[i+1:]
+		user, password, hasPassword := strings.Cut(userinfo, ":")
+		var err error
+		if params["user"], err = url.PathUnescape(user); err != nil {
+			return nil, fmt.Errorf("invalid user in URL")
+		}
+		if hasPassword {
+			if params["password"], err = url.PathUnescape(password); err != nil {
+				return nil, fmt.Errorf("invalid password in URL")
+			}
+		}
+	}
+	var hosts, ports []string
+	for _, hostport := range strings.Split(authority, ",") {
+		host, port := hostport, ""
+		if strings.HasPrefix(hostport, "[") {
+			end := strings.Index(hostport, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid IPv6 host in URL")
+			}
+			host, port = hostport[1:end], strings.TrimPrefix(hostport[end+1:], ":")
+		} else if i := strings.LastIndex(hostport, ":"); i >= 0 {
+			host, port = hostport[:i], hostport[i+1:]
+		}
+		hosts = append(hosts, host)
+		ports = append(ports, port)
+	}
+	if strings.Join(hosts, "") != "" {
+		params["host"] = strings.Join(hosts, ",")
+	}
+	if strings.Join(ports, "") != "" {
+		params["port"] = strings.Join(ports, ",")
+	}
+	if path != "" {
+		dbname, err := url.PathUnescape(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid database name in URL")
+		}
+		params["dbname"] = dbname
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query parameters in URL")
+	}
+	for key, values := range query {
+		params[key] = values[len(values)-1]
+	}
+	return params, nil
+}
+
+func parseDSNKeyValue(dsn string) (map[string]string, error) {
+	params := map[string]string{}
+	s := dsn
+	for {
+		s = strings.TrimLeft(s, " \t\n\r")
+		if s == "" {
+			return params, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("expected key=value after %d parameters", len(params))
+		}
+		key := strings.TrimSpace(s[:eq])
+		if strings.ContainsAny(key, " \t\n\r'") {
+			return nil, fmt.Errorf("invalid key after %d parameters", len(params))
+		}
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var value strings.Builder
+		if strings.HasPrefix(s, "'") {
+			i := 1
+			for ; i < len(s) && s[i] != '\''; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated quoted value for '%s'", key)
+			}
+			s = s[i+1:]
+		} else {
+			i := 0
+			for ; i < len(s) && !strings.ContainsRune(" \t\n\r", rune(s[i])); i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			s = s[i:]
+		}
+		params[key] = value.String()
+	}
+}
+
+// formatDSN builds a key=value connection string, quoting values as libpq expects.
+func formatDSN(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key, value := range params {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+quoteDSNValue(params[key]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\r'\\") {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
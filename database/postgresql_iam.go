@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+	"github.com/lib/pq"
+)
+
+// With authMode iam the password of every new connection is an RDS IAM auth
+// token, signed with the default AWS credential chain like the DynamoDB backend.
+
+// rdsAuthTokenLifetime is how long RDS accepts an auth token for new connections.
+const rdsAuthTokenLifetime = 15 * time.Minute
+
+// validateAuthMode checks the options that depend on authMode, whose value is
+// checked by its enum.
+func (m *PostgreSQLMetadata) validateAuthMode() error {
+	var problems metadataProblems
+	switch m.AuthMode {
+	case "", "password":
+		if m.Password == "" {
+			problems.addf("password or passwordEnv is required")
+		}
+	case "iam":
+		if m.Password != "" {
+			problems.addf("password cannot be combined with authMode iam")
+		}
+		switch m.SSLMode {
+		case "":
+			m.SSLMode = "require"
+		case "disable":
+			problems.addf("authMode iam requires TLS, sslmode cannot be disable")
+		}
+	}
+	return problems.err()
+}
+
+// open returns a connection pool for host.
+func (m *PostgreSQLMetadata) open(host pgHost) (*sql.DB, error) {
+	if m.AuthMode == "iam" {
+		return sql.OpenDB(&pgIAMConnector{meta: m, host: host}), nil
+	}
+	return sql.Open("postgres", m.connectionString(host))
+}
+
+// pgIAMConnector authenticates every connection with a current auth token, so
+// that connections opened by the pool after a token expired still succeed.
+type pgIAMConnector struct {
+	meta *PostgreSQLMetadata
+	host pgHost
+}
+
+func (c *pgIAMConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn, err := c.meta.iamConnectionString(ctx, c.host)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *pgIAMConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// iamConnectionString returns the connection string of host with a current auth token.
+func (m *PostgreSQLMetadata) iamConnectionString(ctx context.Context, host pgHost) (string, error) {
+	token, err := m.iamAuthToken(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	withToken := *m
+	withToken.Password = Secret(token)
+	return withToken.connectionString(host), nil
+}
+
+// iamAuthToken returns a cached auth token for host, or builds a new one when the
+// cached token is about to expire. Loading the AWS config and credentials runs
+// outside the lock of the token cache, so IMDS or STS latency does not delay
+// the tokens of other backends.
+func (m *PostgreSQLMetadata) iamAuthToken(ctx context.Context, host pgHost) (string, error) {
+	key := fmt.Sprintf("rds-iam/%s/%s/%s", m.Region, host, m.User)
+	return getAccessToken(key, func() (accessToken, error) {
+		var cfg aws.Config
+		var err error
+		if m.Region != "" {
+			cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(m.Region))
+		} else {
+			cfg, err = config.LoadDefaultConfig(ctx)
+		}
+		if err != nil {
+			return accessToken{}, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		if cfg.Region == "" {
+			return accessToken{}, errors.New("region is required for authMode iam")
+		}
+		credentials, err := cfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			return accessToken{}, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+		}
+		token, err := auth.BuildAuthToken(ctx, host.String(), cfg.Region, m.User, cfg.Credentials)
+		if err != nil {
+			return accessToken{}, fmt.Errorf("failed to build RDS auth token: %w", err)
+		}
+		// A token signed with temporary credentials is only valid while they are,
+		// so it expires after the RDS lifetime or with the credentials, whichever
+		// is earlier
+		expiry := time.Now().Add(rdsAuthTokenLifetime)
+		if credentials.CanExpire && credentials.Expires.Before(expiry) {
+			expiry = credentials.Expires
+		}
+		return accessToken{Value: token, Expiry: expiry}, nil
+	})
+}
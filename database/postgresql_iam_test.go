@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostgreSQLMetadata_ValidateAuthMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     PostgreSQLMetadata
+		wantErr  bool
+		wantMode string
+		wantSSL  string
+	}{
+		{"password", PostgreSQLMetadata{Password: "secret"}, false, "", ""},
+		{"password missing", PostgreSQLMetadata{}, true, "", ""},
+		{"iam forces TLS", PostgreSQLMetadata{AuthMode: "iam"}, false, "iam", "require"},
+		{"iam keeps verify-full", PostgreSQLMetadata{AuthMode: "iam", SSLMode: "verify-full"}, false, "iam", "verify-full"},
+		{"iam without TLS", PostgreSQLMetadata{AuthMode: "iam", SSLMode: "disable"}, true, "", ""},
+		{"iam with password", PostgreSQLMetadata{AuthMode: "iam", Password: "secret"}, true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.meta.validateAuthMode()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && (tt.meta.AuthMode != tt.wantMode || tt.meta.SSLMode != tt.wantSSL) {
+				t.Errorf("expected authMode %s and sslmode %q, got %s and %q", tt.wantMode, tt.wantSSL, tt.meta.AuthMode, tt.meta.SSLMode)
+			}
+		})
+	}
+}
+
+func TestPostgreSQLMetadata_IAMConnectionString(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	meta := &PostgreSQLMetadata{
+		User: "scaler", Database: "calendar", AuthMode: "iam", Region: "ap-northeast-1", SSLMode: "require",
+	}
+	host := pgHost{Host: "calendar.abc123.ap-northeast-1.rds.amazonaws.com", Port: "5432"}
+	dsn, err := meta.iamConnectionString(context.Background(), host)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{"password=" + host.String() + "?Action=connect", "DBUser=scaler", "X-Amz-Signature=", "sslmode=require"} {
+		if !strings.Contains(dsn, expected) {
+			t.Errorf("expected %q in connection string %s", expected, dsn)
+		}
+	}
+	if meta.Password != "" {
+		t.Error("the token must not be stored in the metadata")
+	}
+	again, err := meta.iamConnectionString(context.Background(), host)
+	if err != nil || again != dsn {
+		t.Errorf("expected the cached token to be reused, got %v", err)
+	}
+}
+
+func TestPgNotifier_RenewsConnectionString(t *testing.T) {
+	// The server drops every connection, like RDS rejecting an expired token
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	dsn := "postgres://user:token@" + server.Addr().String() + "/calendar?sslmode=disable"
+	renewed := make(chan struct{}, 1)
+	renew := func() (string, error) {
+		select {
+		case renewed <- struct{}{}:
+		default:
+		}
+		return dsn, nil
+	}
+
+	n := &pgNotifier{subscribers: map[chan struct{}]struct{}{}}
+	go n.run("calendar_events_changed", pgHost{Host: "127.0.0.1"}, dsn, renew)
+	defer func() {
+		pgNotifiers.Lock()
+		defer pgNotifiers.Unlock()
+		n.closed = true
+		if n.listener != nil {
+			n.listener.Close()
+		}
+	}()
+	select {
+	case <-renewed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a failed connection attempt to renew the connection string")
+	}
+}
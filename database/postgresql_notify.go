@@ -0,0 +1,243 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Triggers with a notifyChannel LISTEN on that channel so that changes to the
+// calendar table are pushed to StreamIsActive immediately instead of waiting for
+// the next poll. The table needs the trigger installed by NotifyTriggerSQL.
+
+const (
+	notifyMinReconnectInterval = time.Second
+	notifyMaxReconnectInterval = time.Minute
+)
+
+// pgNotifier is a shared LISTEN connection for one server and channel. Its
+// fields are guarded by pgNotifiers.
+type pgNotifier struct {
+	listener    *pq.Listener
+	subscribers map[chan struct{}]struct{}
+	closed      bool
+}
+
+// pgNotifiers holds one LISTEN connection per connection string and channel,
+// shared by all streams that subscribe to it.
+var pgNotifiers = struct {
+	sync.Mutex
+	m map[string]*pgNotifier
+}{m: map[string]*pgNotifier{}}
+
+func notifyChannelName(channel string) (string, error) {
+	parts, err := parseIdentifier(channel, 1)
+	if err != nil {
+		return "", fmt.Errorf("notifyChannel: %w", err)
+	}
+	return parts[0], nil
+}
+
+// Changes subscribes to the notifyChannel of the trigger. Notifications are
+// also sent after the LISTEN connection is re-established, since changes may
+// have been missed while it was down.
+func (db *PostgresDB) Changes(ctx context.Context) (<-chan struct{}, error) {
+	if db.Meta.NotifyChannel == "" {
+		return nil, nil
+	}
+	withoutPassword := *db.Meta
+	withoutPassword.Password = ""
+	key := withoutPassword.connectionString(db.host) + "#" + db.Meta.NotifyChannel
+	dsn := db.Meta.connectionString(db.host)
+	var renew func() (string, error)
+	if db.Meta.AuthMode == "iam" {
+		// pq.Listener reconnects with the same connection string, but RDS only
+		// accepts a token for 15 minutes, so failed reconnects get a new token
+		meta, host := *db.Meta, db.host
+		renew = func() (string, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyMaxReconnectInterval)
+			defer cancel()
+			return meta.iamConnectionString(ctx, host)
+		}
+		var err error
+		if dsn, err = db.Meta.iamConnectionString(ctx, db.host); err != nil {
+			return nil, err
+		}
+	}
+	ch := make(chan struct{}, 1)
+
+	pgNotifiers.Lock()
+	n, ok := pgNotifiers.m[key]
+	if !ok {
+		n = &pgNotifier{subscribers: map[chan struct{}]struct{}{}}
+		pgNotifiers.m[key] = n
+		go n.run(db.Meta.NotifyChannel, db.host, dsn, renew)
+	}
+	n.subscribers[ch] = struct{}{}
+	pgNotifiers.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		pgNotifiers.Lock()
+		defer pgNotifiers.Unlock()
+		delete(n.subscribers, ch)
+		close(ch)
+		if len(n.subscribers) == 0 {
+			delete(pgNotifiers.m, key)
+			n.closed = true
+			if n.listener != nil {
+				n.listener.Close()
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// run listens on channel until the notifier is closed. With renew, a listener
+// whose connection attempt failed is replaced by one with the connection string
+// returned by renew.
+func (n *pgNotifier) run(channel string, host pgHost, dsn string, renew func() (string, error)) {
+	wait := notifyMinReconnectInterval
+	for rebuilt := false; ; rebuilt = true {
+		if rebuilt {
+			time.Sleep(wait)
+			var err error
+			if dsn, err = renew(); err != nil {
+				fmt.Printf("[PostgreSQL Error] LISTEN %s on %s: %v\n", channel, host, err)
+				wait = min(2*wait, notifyMaxReconnectInterval)
+				pgNotifiers.Lock()
+				closed := n.closed
+				pgNotifiers.Unlock()
+				if closed {
+					return
+				}
+				continue
+			}
+		}
+		failed := make(chan struct{}, 1)
+		var connected atomic.Bool
+		listener := pq.NewListener(dsn, notifyMinReconnectInterval, notifyMaxReconnectInterval, func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				fmt.Printf("[PostgreSQL Error] LISTEN %s on %s: %v\n", channel, host, err)
+			}
+			switch event {
+			case pq.ListenerEventConnected, pq.ListenerEventReconnected:
+				connected.Store(true)
+			case pq.ListenerEventConnectionAttemptFailed:
+				if renew != nil {
+					select {
+					case failed <- struct{}{}:
+					default:
+					}
+				}
+			}
+		})
+		pgNotifiers.Lock()
+		if n.closed {
+			pgNotifiers.Unlock()
+			listener.Close()
+			return
+		}
+		n.listener = listener
+		pgNotifiers.Unlock()
+
+		go func() {
+			// Listen blocks until the first connection is established
+			err := listener.Listen(channel)
+			if err != nil && err != pq.ErrChannelAlreadyOpen {
+				fmt.Printf("[PostgreSQL Error] failed to LISTEN %s: %v\n", channel, err)
+			} else if err == nil && rebuilt {
+				// Changes may have been missed while the connection was down
+				n.notify()
+			}
+		}()
+		if !n.forward(listener, failed) {
+			return
+		}
+		listener.Close()
+		if connected.Load() {
+			wait = notifyMinReconnectInterval
+		} else {
+			wait = min(2*wait, notifyMaxReconnectInterval)
+		}
+	}
+}
+
+// forward passes the notifications of listener to the subscribers. It returns
+// false when the listener was closed, and true when it should be replaced.
+func (n *pgNotifier) forward(listener *pq.Listener, failed <-chan struct{}) bool {
+	for {
+		select {
+		case _, ok := <-listener.Notify:
+			if !ok {
+				return false
+			}
+			n.notify()
+		case <-failed:
+			return true
+		}
+	}
+}
+
+func (n *pgNotifier) notify() {
+	pgNotifiers.Lock()
+	defer pgNotifiers.Unlock()
+	for ch := range n.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// NotifyTriggerSQL returns the statements that make table send a notification on
+// channel after every INSERT, UPDATE, DELETE or TRUNCATE. The function is
+// shared by all tables; the trigger passes the channel name as its argument.
+func NotifyTriggerSQL(table string, channel string) (string, error) {
+	quotedTable, err := quoteIdentifier(table, 2)
+	if err != nil {
+		return "", fmt.Errorf("table: %w", err)
+	}
+	channel, err = notifyChannelName(channel)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`CREATE OR REPLACE FUNCTION calendar_scaler_notify() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	PERFORM pg_notify(TG_ARGV[0], TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME);
+	RETURN NULL;
+END
+$$;
+DROP TRIGGER IF EXISTS calendar_scaler_notify ON %[1]s;
+CREATE TRIGGER calendar_scaler_notify
+	AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON %[1]s
+	FOR EACH STATEMENT EXECUTE FUNCTION calendar_scaler_notify(%[2]s);
+`, quotedTable, pq.QuoteLiteral(channel)), nil
+}
+
+// InstallNotifyTrigger runs NotifyTriggerSQL for table in a single transaction.
+func InstallNotifyTrigger(dsn string, table string, channel string) error {
+	statements, err := NotifyTriggerSQL(table, channel)
+	if err != nil {
+		return err
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
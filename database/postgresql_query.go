@@ -0,0 +1,319 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Identifiers from the trigger metadata are never spliced into queries as-is.
+// They are parsed like PostgreSQL does (unquoted names are folded to lower case,
+// double-quoted names keep their case) and always emitted quoted.
+
+const maxIdentifierLength = 63
+
+var unquotedIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// parseIdentifier splits a possibly qualified identifier such as `schema.table`
+// or `"Schema"."MixedCase"` into its parts.
+func parseIdentifier(name string, maxParts int) ([]string, error) {
+	var parts []string
+	rest := strings.TrimSpace(name)
+	for {
+		var part string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest); i++ {
+				if rest[i] == '"' {
+					if i+1 < len(rest) && rest[i+1] == '"' {
+						b.WriteByte('"')
+						i++
+						continue
+					}
+					break
+				}
+				b.WriteByte(rest[i])
+			}
+			if i >= len(rest) {
+				return nil, fmt.Errorf("invalid identifier '%s': unterminated quoted identifier", name)
+			}
+			part = b.String()
+			rest = rest[i+1:]
+			if part == "" || strings.ContainsRune(part, 0) {
+				return nil, fmt.Errorf("invalid identifier '%s'", name)
+			}
+		} else {
+			end := strings.IndexByte(rest, '.')
+			if end < 0 {
+				end = len(rest)
+			}
+			part = rest[:end]
+			rest = rest[end:]
+			if !unquotedIdentifierPattern.MatchString(part) {
+				return nil, fmt.Errorf("invalid identifier '%s': use letters, digits, '_' or a double-quoted name", name)
+			}
+			part = strings.ToLower(part)
+		}
+		if len(part) > maxIdentifierLength {
+			return nil, fmt.Errorf("invalid identifier '%s': longer than %d characters", name, maxIdentifierLength)
+		}
+		parts = append(parts, part)
+		if rest == "" {
+			break
+		}
+		if !strings.HasPrefix(rest, ".") {
+			return nil, fmt.Errorf("invalid identifier '%s'", name)
+		}
+		rest = rest[1:]
+	}
+	if len(parts) > maxParts {
+		return nil, fmt.Errorf("invalid identifier '%s': at most %d dot-separated parts allowed", name, maxParts)
+	}
+	return parts, nil
+}
+
+// quoteIdentifier validates name and returns it quoted for use in a query.
+func quoteIdentifier(name string, maxParts int) (string, error) {
+	parts, err := parseIdentifier(name, maxParts)
+	if err != nil {
+		return "", err
+	}
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// pgIdentifiers holds the quoted table and column names of a trigger.
+type pgIdentifiers struct {
+	Table           string
+	StartTime       string
+	EndTime         string
+	Range           string
+	DesiredReplicas string
+	Target          string
+	Cron            string
+	Duration        string
+	FilterColumns   []string
+}
+
+func (m *PostgreSQLMetadata) quotedIdentifiers() (*pgIdentifiers, error) {
+	ident := &pgIdentifiers{}
+	tableParts := 2
+	if m.Schema != "" {
+		tableParts = 1
+	}
+	identifiers := []struct {
+		param    string
+		value    string
+		maxParts int
+		dest     *string
+	}{
+		{"table", m.Table, tableParts, &ident.Table},
+		{"startColumn", m.StartTimeColumn, 1, &ident.StartTime},
+		{"endColumn", m.EndTimeColumn, 1, &ident.EndTime},
+		{"rangeColumn", m.RangeColumn, 1, &ident.Range},
+		{"desiredReplicasColumn", m.DesiredReplicasColumn, 1, &ident.DesiredReplicas},
+		{"targetColumn", m.TargetColumn, 1, &ident.Target},
+		{"cronColumn", m.CronColumn, 1, &ident.Cron},
+		{"durationColumn", m.DurationColumn, 1, &ident.Duration},
+	}
+	for _, id := range identifiers {
+		if id.value == "" {
+			continue
+		}
+		quoted, err := quoteIdentifier(id.value, id.maxParts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id.param, err)
+		}
+		*id.dest = quoted
+	}
+	if m.Schema != "" {
+		schema, err := quoteIdentifier(m.Schema, 1)
+		if err != nil {
+			return nil, fmt.Errorf("schema: %w", err)
+		}
+		ident.Table = schema + "." + ident.Table
+	}
+	for i, filter := range m.Filters {
+		column, err := quoteIdentifier(filter.Column, 1)
+		if err != nil {
+			return nil, fmt.Errorf("filters.%d.column: %w", i, err)
+		}
+		ident.FilterColumns = append(ident.FilterColumns, column)
+	}
+	return ident, nil
+}
+
+// PostgreSQLFilter is an additional predicate on the calendar table, configured with
+// indexed metadata such as "filters.0.column", "filters.0.operator" and "filters.0.value".
+// Values are always passed as query parameters.
+type PostgreSQLFilter struct {
+	Column   string
+	Operator string
+	Value    string
+}
+
+// postgreSQLFilterOperators maps the accepted operators to their SQL form.
+var postgreSQLFilterOperators = map[string]string{
+	"=":           "=",
+	"!=":          "<>",
+	"<>":          "<>",
+	"<":           "<",
+	"<=":          "<=",
+	">":           ">",
+	">=":          ">=",
+	"like":        "LIKE",
+	"ilike":       "ILIKE",
+	"in":          "IN",
+	"not in":      "NOT IN",
+	"is null":     "IS NULL",
+	"is not null": "IS NOT NULL",
+}
+
+func parsePostgreSQLFilters(metadata map[string]string) ([]PostgreSQLFilter, error) {
+	filters := map[int]*PostgreSQLFilter{}
+	for key, value := range metadata {
+		rest, ok := strings.CutPrefix(key, "filters.")
+		if !ok {
+			continue
+		}
+		indexStr, field, _ := strings.Cut(rest, ".")
+		index, err := strconv.Atoi(indexStr)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("invalid filter key '%s' (expected filters.<index>.<column|operator|value>)", key)
+		}
+		filter, exists := filters[index]
+		if !exists {
+			filter = &PostgreSQLFilter{}
+			filters[index] = filter
+		}
+		switch field {
+		case "column":
+			filter.Column = value
+		case "operator":
+			filter.Operator = strings.ToLower(strings.Join(strings.Fields(value), " "))
+		case "value":
+			filter.Value = value
+		default:
+			return nil, fmt.Errorf("invalid filter key '%s' (expected filters.<index>.<column|operator|value>)", key)
+		}
+	}
+	indexes := make([]int, 0, len(filters))
+	for index := range filters {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	result := make([]PostgreSQLFilter, 0, len(indexes))
+	for _, index := range indexes {
+		filter := filters[index]
+		if filter.Column == "" {
+			return nil, fmt.Errorf("filters.%d.column is required", index)
+		}
+		if filter.Operator == "" {
+			filter.Operator = "="
+		}
+		if _, ok := postgreSQLFilterOperators[filter.Operator]; !ok {
+			return nil, fmt.Errorf("filters.%d.operator '%s' is not supported", index, filter.Operator)
+		}
+		if !strings.HasSuffix(filter.Operator, "null") && filter.Value == "" {
+			return nil, fmt.Errorf("filters.%d.value is required for operator '%s'", index, filter.Operator)
+		}
+		if strings.HasSuffix(filter.Operator, "in") && len(splitList(filter.Value)) == 0 {
+			return nil, fmt.Errorf("filters.%d.value must be a comma-separated list for operator '%s'", index, filter.Operator)
+		}
+		result = append(result, *filter)
+	}
+	return result, nil
+}
+
+// pgQuery collects the parameters of a query and hands out their placeholders.
+type pgQuery struct {
+	args []interface{}
+}
+
+func (q *pgQuery) arg(value interface{}) string {
+	q.args = append(q.args, value)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// timeSelect returns the select list of the event window. Unbounded and
+// infinite sides of a range are returned as NULL.
+func (db *PostgresDB) timeSelect() string {
+	if db.Meta.RangeColumn == "" {
+		return db.ident.StartTime + ", " + db.ident.EndTime
+	}
+	return fmt.Sprintf(
+		"CASE WHEN isfinite(lower(%[1]s)) THEN lower(%[1]s) END, CASE WHEN isfinite(upper(%[1]s)) THEN upper(%[1]s) END",
+		db.ident.Range,
+	)
+}
+
+// cronSelect returns the additional select list for recurring (cron) events.
+func (db *PostgresDB) cronSelect() string {
+	if db.Meta.CronColumn == "" {
+		return ""
+	}
+	return fmt.Sprintf(", %s, %s::text", db.ident.Cron, db.ident.Duration)
+}
+
+// postgreSQLTargetMatchers match a target column of the given type against a
+// scaledobject identifier. Each expression can be served by a GIN index:
+//
+//	text:  CREATE INDEX ON calendar_events USING GIN (regexp_split_to_array(trim(target), '\s*,\s*'));
+//	array: CREATE INDEX ON calendar_events USING GIN (target);
+//	jsonb: CREATE INDEX ON calendar_events USING GIN (target);
+var postgreSQLTargetMatchers = map[string]string{
+	"text":  `regexp_split_to_array(trim(%s), '\s*,\s*') @> ARRAY[%s::text]`,
+	"array": `%s @> ARRAY[%s::text]`,
+	"jsonb": `%s @> jsonb_build_array(%s::text)`,
+}
+
+// whereClause matches rows active at now that belong to the scaledobject and
+// satisfy the configured filters. Recurring rows are always returned and their
+// cron expression is evaluated in Go.
+func (db *PostgresDB) whereClause(q *pgQuery, now time.Time) string {
+	nowArg := db.nowArg(q, now)
+	var condition string
+	if db.Meta.RangeColumn != "" {
+		// @> honours the bounds of the range and can use a GiST index
+		condition = fmt.Sprintf("%s @> %s", db.ident.Range, nowArg)
+	} else {
+		condition = fmt.Sprintf("%s <= %s AND %s <= %s", db.ident.StartTime, nowArg, nowArg, db.ident.EndTime)
+	}
+	if db.Meta.CronColumn != "" {
+		condition = fmt.Sprintf("(%s) OR (%s IS NOT NULL AND %s <> '')", condition, db.ident.Cron, db.ident.Cron)
+	}
+	if db.Meta.TargetColumn == "" && len(db.Meta.Filters) == 0 {
+		return condition
+	}
+	conditions := []string{"(" + condition + ")"}
+	if db.Meta.TargetColumn != "" {
+		targetKey := db.Meta.Namespace + "/" + db.Meta.ScaledObject
+		matcher := postgreSQLTargetMatchers[db.Meta.TargetColumnType]
+		conditions = append(conditions, fmt.Sprintf(matcher, db.ident.Target, q.arg(targetKey)))
+	}
+	for i, filter := range db.Meta.Filters {
+		column := db.ident.FilterColumns[i]
+		operator := postgreSQLFilterOperators[filter.Operator]
+		switch operator {
+		case "IS NULL", "IS NOT NULL":
+			conditions = append(conditions, column+" "+operator)
+		case "IN", "NOT IN":
+			var placeholders []string
+			for _, value := range splitList(filter.Value) {
+				placeholders = append(placeholders, q.arg(value))
+			}
+			conditions = append(conditions, fmt.Sprintf("%s %s (%s)", column, operator, strings.Join(placeholders, ", ")))
+		default:
+			conditions = append(conditions, fmt.Sprintf("%s %s %s", column, operator, q.arg(filter.Value)))
+		}
+	}
+	return strings.Join(conditions, " AND ")
+}
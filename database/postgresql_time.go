@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// timestamptz columns are compared with the current instant. timestamp (without
+// time zone) columns hold wall-clock times in the trigger timezone, so they are
+// compared with the current wall-clock time in that timezone and scanned values
+// are reinterpreted in it.
+
+// postgreSQLColumnTimeTypes maps the accepted columnTimeType values to the type
+// of the current time parameter. auto detects the type from the catalog.
+var postgreSQLColumnTimeTypes = map[string]string{
+	"auto":        "",
+	"timestamptz": "timestamptz",
+	"timestamp":   "timestamp",
+}
+
+// postgreSQLTimeTypes maps the catalog names of the supported column types (or
+// range element types) to their short names.
+var postgreSQLTimeTypes = map[string]string{
+	"timestamp with time zone":    "timestamptz",
+	"timestamp without time zone": "timestamp",
+}
+
+// wallClockLayout formats the current time for timestamp columns.
+const wallClockLayout = "2006-01-02 15:04:05.999999"
+
+// pgColumnTypes caches the type of time columns per server and column. For range
+// columns the element type is cached.
+var pgColumnTypes = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// detectTimeType returns the type of the time columns, from columnTimeType or the
+// catalog. Start and end columns of other or differing types keep the untyped
+// comparison, a range column must be a tsrange or tstzrange.
+func (db *PostgresDB) detectTimeType() (string, error) {
+	if timeType := postgreSQLColumnTimeTypes[db.Meta.ColumnTimeType]; timeType != "" {
+		return timeType, nil
+	}
+	if db.Meta.RangeColumn != "" {
+		elementType, err := db.columnType(db.Meta.RangeColumn, true)
+		if err != nil {
+			return "", err
+		}
+		timeType, ok := postgreSQLTimeTypes[elementType]
+		if !ok {
+			return "", fmt.Errorf("rangeColumn '%s' must be a tsrange or tstzrange column, not a range of %s", db.Meta.RangeColumn, elementType)
+		}
+		return timeType, nil
+	}
+	startType, err := db.columnType(db.Meta.StartTimeColumn, false)
+	if err != nil {
+		return "", err
+	}
+	endType, err := db.columnType(db.Meta.EndTimeColumn, false)
+	if err != nil {
+		return "", err
+	}
+	if startType != endType {
+		return "", nil
+	}
+	return postgreSQLTimeTypes[startType], nil
+}
+
+// columnType looks up the type of column in the catalog, or the element type if
+// rangeElement is set.
+func (db *PostgresDB) columnType(name string, rangeElement bool) (string, error) {
+	column, err := parseIdentifier(name, 1)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s/%s/%s/%s/%t", db.host, db.Meta.Database, db.ident.Table, column[0], rangeElement)
+	pgColumnTypes.Lock()
+	columnType, ok := pgColumnTypes.m[key]
+	pgColumnTypes.Unlock()
+	if ok {
+		return columnType, nil
+	}
+
+	query := `SELECT format_type(a.atttypid, NULL) FROM pg_attribute a
+		WHERE a.attrelid = $1::regclass AND a.attname = $2 AND NOT a.attisdropped`
+	if rangeElement {
+		query = `SELECT format_type(r.rngsubtype, NULL) FROM pg_attribute a JOIN pg_range r ON r.rngtypid = a.atttypid
+		WHERE a.attrelid = $1::regclass AND a.attname = $2 AND NOT a.attisdropped`
+	}
+	err = db.Conn.QueryRow(query, db.ident.Table, column[0]).Scan(&columnType)
+	if err == sql.ErrNoRows && rangeElement {
+		return "", fmt.Errorf("rangeColumn '%s' is not a range column of %s", name, db.ident.Table)
+	}
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("column '%s' does not exist in %s", name, db.ident.Table)
+	}
+	if err != nil {
+		return "", err
+	}
+	pgColumnTypes.Lock()
+	pgColumnTypes.m[key] = columnType
+	pgColumnTypes.Unlock()
+	return columnType, nil
+}
+
+// nowArg adds the current time as a query parameter of the type of the time columns.
+func (db *PostgresDB) nowArg(q *pgQuery, now time.Time) string {
+	switch db.timeType {
+	case "timestamp":
+		return q.arg(now.Format(wallClockLayout)) + "::timestamp"
+	case "timestamptz":
+		return q.arg(now) + "::timestamptz"
+	default:
+		return q.arg(now)
+	}
+}
+
+// inLocation reinterprets the wall-clock time of a naive timestamp in location.
+// Wall-clock times skipped by a DST transition are moved forward by the length
+// of the transition, repeated ones resolve to the later occurrence.
+func inLocation(t time.Time, location *time.Location) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), location)
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInLocation_DST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+	tests := []struct {
+		name      string
+		wallClock time.Time
+		expected  time.Time
+	}{
+		{"winter", time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)},
+		{"summer", time.Date(2024, 7, 15, 9, 0, 0, 0, time.UTC), time.Date(2024, 7, 15, 7, 0, 0, 0, time.UTC)},
+		{"before spring forward", time.Date(2024, 3, 31, 1, 59, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 59, 0, 0, time.UTC)},
+		{"skipped by spring forward", time.Date(2024, 3, 31, 2, 30, 0, 0, time.UTC), time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC)},
+		{"after spring forward", time.Date(2024, 3, 31, 3, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC)},
+		{"repeated by fall back", time.Date(2024, 10, 27, 2, 30, 0, 0, time.UTC), time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC)},
+		{"after fall back", time.Date(2024, 10, 27, 3, 0, 0, 0, time.UTC), time.Date(2024, 10, 27, 2, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := inLocation(tt.wallClock, berlin)
+			if !got.Equal(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got.UTC())
+			}
+			if got.Location() != berlin {
+				t.Errorf("expected location %v, got %v", berlin, got.Location())
+			}
+		})
+	}
+	if !inLocation(time.Time{}, berlin).IsZero() {
+		t.Error("expected NULL times to stay zero")
+	}
+}
+
+func TestPostgresDB_NowArg(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+	// 01:30 UTC is 03:30 CEST, right after the spring forward transition
+	now := time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC).In(berlin)
+	tests := []struct {
+		timeType string
+		expected string
+		arg      interface{}
+	}{
+		{"timestamp", "$1::timestamp", "2024-03-31 03:30:00"},
+		{"timestamptz", "$1::timestamptz", now},
+		{"", "$1", now},
+	}
+	for _, tt := range tests {
+		q := &pgQuery{}
+		got := (&PostgresDB{timeType: tt.timeType}).nowArg(q, now)
+		if got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.timeType, tt.expected, got)
+		}
+		if q.args[0] != tt.arg {
+			t.Errorf("%q: expected parameter %v, got %v", tt.timeType, tt.arg, q.args[0])
+		}
+	}
+}
+
+func TestPostgresDB_DetectTimeTypeFromOption(t *testing.T) {
+	for option, expected := range map[string]string{"timestamp": "timestamp", "timestamptz": "timestamptz"} {
+		db := &PostgresDB{Meta: &PostgreSQLMetadata{ColumnTimeType: option, RangeColumn: "during"}}
+		got, err := db.detectTimeType()
+		if err != nil || got != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", option, expected, got, err)
+		}
+	}
+}
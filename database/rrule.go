@@ -0,0 +1,214 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurring iCalendar events are evaluated like cron entries: icsRecurrence
+// implements cron.Schedule and the event keeps the duration of its first
+// occurrence. RRULEs with FREQ=DAILY, WEEKLY, MONTHLY or YEARLY and INTERVAL,
+// COUNT, UNTIL, WKST and BYDAY (weekdays without a position, with DAILY or
+// WEEKLY) are supported, together with RDATE and EXDATE. Other rules are
+// rejected rather than evaluated incorrectly.
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+type icsRecurrence struct {
+	start    time.Time
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+	wkst     time.Weekday
+	rdates   []time.Time
+	exdates  []time.Time
+}
+
+// parseRRule parses the value of an RRULE property of an event starting at start.
+func parseRRule(rule string, start time.Time) (*icsRecurrence, error) {
+	r := &icsRecurrence{start: start, interval: 1, wkst: time.Monday}
+	for _, part := range strings.Split(rule, ";") {
+		name, value, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			r.freq = strings.ToUpper(value)
+			if !slices.Contains([]string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}, r.freq) {
+				return nil, fmt.Errorf("unsupported FREQ=%s (must be DAILY, WEEKLY, MONTHLY or YEARLY)", value)
+			}
+		case "INTERVAL":
+			if r.interval, err = strconv.Atoi(value); err != nil || r.interval < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL '%s'", value)
+			}
+		case "COUNT":
+			if r.count, err = strconv.Atoi(value); err != nil || r.count < 1 {
+				return nil, fmt.Errorf("invalid COUNT '%s'", value)
+			}
+		case "UNTIL":
+			if r.until, err = parseICSTime(icsProperty{Value: value}, start.Location()); err != nil {
+				return nil, fmt.Errorf("invalid UNTIL '%s'", value)
+			}
+			if len(value) == 8 {
+				// A date includes occurrences on that day
+				r.until = r.until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+		case "WKST":
+			day, ok := icsWeekdays[strings.ToUpper(value)]
+			if !ok {
+				return nil, fmt.Errorf("invalid WKST '%s'", value)
+			}
+			r.wkst = day
+		case "BYDAY":
+			for _, v := range strings.Split(value, ",") {
+				day, ok := icsWeekdays[strings.ToUpper(v)]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY value '%s' (only weekdays without a position are supported)", v)
+				}
+				r.byDay = append(r.byDay, day)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", name)
+		}
+	}
+	switch {
+	case r.freq == "":
+		return nil, errors.New("FREQ is required")
+	case r.count > 0 && !r.until.IsZero():
+		return nil, errors.New("COUNT and UNTIL cannot be combined")
+	case len(r.byDay) > 0 && r.freq != "DAILY" && r.freq != "WEEKLY":
+		return nil, fmt.Errorf("BYDAY is not supported with FREQ=%s", r.freq)
+	}
+	return r, nil
+}
+
+// Next returns the first start of an occurrence after t, or the zero time if
+// there is none.
+func (r *icsRecurrence) Next(t time.Time) time.Time {
+	var next time.Time
+	r.occurrences(t, func(start time.Time) bool {
+		if start.After(t) && !r.excluded(start) {
+			next = start
+			return false
+		}
+		return true
+	})
+	for _, rdate := range r.rdates {
+		if rdate.After(t) && !r.excluded(rdate) && (next.IsZero() || rdate.Before(next)) {
+			next = rdate
+		}
+	}
+	return next
+}
+
+func (r *icsRecurrence) excluded(start time.Time) bool {
+	return slices.ContainsFunc(r.exdates, start.Equal)
+}
+
+// occurrences passes the starts of the rule to yield in ascending order, from a
+// period shortly before after, until yield returns false or the rule ends.
+func (r *icsRecurrence) occurrences(after time.Time, yield func(time.Time) bool) {
+	// Without an end, give up once no occurrence can follow any more
+	limit := after.AddDate(10*r.interval, 0, 1)
+	n := 0
+	emit := func(start time.Time) bool {
+		if start.Before(r.start) {
+			return true
+		}
+		if !r.until.IsZero() && start.After(r.until) {
+			return false
+		}
+		n++
+		if r.count > 0 && n > r.count {
+			return false
+		}
+		return yield(start)
+	}
+
+	switch r.freq {
+	case "":
+		// Only RDATEs, the event itself is the first occurrence
+		emit(r.start)
+	case "DAILY":
+		for k := r.skip(after); ; k++ {
+			start := r.start.AddDate(0, 0, k*r.interval)
+			if start.After(limit) {
+				return
+			}
+			if len(r.byDay) > 0 && !slices.Contains(r.byDay, start.Weekday()) {
+				continue
+			}
+			if !emit(start) {
+				return
+			}
+		}
+	case "WEEKLY":
+		offset := func(day time.Weekday) int {
+			return (int(day) - int(r.wkst) + 7) % 7
+		}
+		days := slices.Clone(r.byDay)
+		if len(days) == 0 {
+			days = []time.Weekday{r.start.Weekday()}
+		}
+		slices.SortFunc(days, func(a, b time.Weekday) int { return offset(a) - offset(b) })
+		weekStart := r.start.AddDate(0, 0, -offset(r.start.Weekday()))
+		for k := r.skip(after); ; k++ {
+			week := weekStart.AddDate(0, 0, 7*k*r.interval)
+			if week.After(limit) {
+				return
+			}
+			for _, day := range days {
+				if !emit(week.AddDate(0, 0, offset(day))) {
+					return
+				}
+			}
+		}
+	case "MONTHLY", "YEARLY":
+		for k := r.skip(after); ; k++ {
+			var start time.Time
+			if r.freq == "MONTHLY" {
+				start = r.start.AddDate(0, k*r.interval, 0)
+			} else {
+				start = r.start.AddDate(k*r.interval, 0, 0)
+			}
+			if start.After(limit) {
+				return
+			}
+			if start.Day() != r.start.Day() {
+				// The day does not exist in this month, e.g. the 31st or February 29th
+				continue
+			}
+			if !emit(start) {
+				return
+			}
+		}
+	}
+}
+
+// skip returns the index of a period shortly before after, so rules without
+// COUNT are not expanded from DTSTART on every evaluation.
+func (r *icsRecurrence) skip(after time.Time) int {
+	if r.count > 0 || !after.After(r.start) {
+		return 0
+	}
+	var periods int
+	switch r.freq {
+	case "DAILY":
+		periods = int(after.Sub(r.start).Hours() / 24)
+	case "WEEKLY":
+		periods = int(after.Sub(r.start).Hours() / (24 * 7))
+	case "MONTHLY":
+		periods = (after.Year()-r.start.Year())*12 + int(after.Month()) - int(r.start.Month())
+	case "YEARLY":
+		periods = after.Year() - r.start.Year()
+	}
+	return max(0, periods/r.interval-1)
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseICSSchedule_Recurrence(t *testing.T) {
+	location, _ := time.LoadLocation("Asia/Tokyo")
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 6, day, hour, 0, 0, 0, location)
+	}
+	// Tuesday 2024-06-11 09:00-18:00
+	event := []string{"DTSTART:20240611T090000", "DTEND:20240611T180000"}
+	tests := []struct {
+		name   string
+		props  []string
+		active []time.Time
+		idle   []time.Time
+	}{
+		{
+			name:   "daily with count",
+			props:  []string{"RRULE:FREQ=DAILY;COUNT=3"},
+			active: []time.Time{at(11, 10), at(13, 17)},
+			idle:   []time.Time{at(10, 10), at(12, 20), at(14, 10)},
+		},
+		{
+			name:   "weekly on weekdays",
+			props:  []string{"RRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+			active: []time.Time{at(14, 10), at(17, 9), time.Date(2025, 1, 6, 12, 0, 0, 0, location)},
+			idle:   []time.Time{at(10, 10), at(15, 10), at(16, 10)},
+		},
+		{
+			name:   "every other week until",
+			props:  []string{"RRULE:FREQ=WEEKLY;INTERVAL=2;UNTIL=20240625"},
+			active: []time.Time{at(11, 10), at(25, 10)},
+			idle:   []time.Time{at(18, 10), time.Date(2024, 7, 9, 10, 0, 0, 0, location)},
+		},
+		{
+			name:   "exdate and rdate",
+			props:  []string{"RRULE:FREQ=DAILY;UNTIL=20240614T090000", "EXDATE:20240612T090000,20240613T090000", "RDATE:20240615T090000"},
+			active: []time.Time{at(11, 10), at(14, 10), at(15, 10)},
+			idle:   []time.Time{at(12, 10), at(13, 10), at(16, 10)},
+		},
+		{
+			name:   "rdate without rrule",
+			props:  []string{"RDATE;TZID=Asia/Tokyo:20240620T090000"},
+			active: []time.Time{at(11, 10), at(20, 10)},
+			idle:   []time.Time{at(12, 10)},
+		},
+	}
+	for _, tt := range tests {
+		events, err := parseICSSchedule(icsDocument(append(event, tt.props...)...), location)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		for _, now := range tt.active {
+			if active := activeEvents(events, now, "default/app"); len(active) != 1 || active[0].DesiredReplicas != 3 {
+				t.Errorf("%s: expected an event at %s, got %+v", tt.name, now, active)
+			}
+		}
+		for _, now := range tt.idle {
+			if active := activeEvents(events, now, "default/app"); len(active) != 0 {
+				t.Errorf("%s: expected no event at %s, got %+v", tt.name, now, active)
+			}
+		}
+	}
+}
+
+func TestICSRecurrence_SkipsMissingDays(t *testing.T) {
+	start := time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+	r, err := parseRRule("FREQ=MONTHLY", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := r.Next(start); !next.Equal(time.Date(2024, 3, 31, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected February to be skipped, got %s", next)
+	}
+	r, _ = parseRRule("FREQ=YEARLY", time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC))
+	if next := r.Next(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)); !next.Equal(time.Date(2028, 2, 29, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the next leap day, got %s", next)
+	}
+}
+
+func TestParseICSSchedule_UnsupportedRecurrence(t *testing.T) {
+	tests := map[string]string{
+		"RRULE:FREQ=HOURLY":                        "unsupported FREQ=HOURLY",
+		"RRULE:FREQ=MONTHLY;BYDAY=1MO":             "unsupported BYDAY value '1MO'",
+		"RRULE:FREQ=MONTHLY;BYMONTHDAY=1":          "unsupported rule part BYMONTHDAY",
+		"RRULE:FREQ=DAILY;COUNT=2;UNTIL=20250101":  "COUNT and UNTIL cannot be combined",
+		"RRULE:INTERVAL=2":                         "FREQ is required",
+		"RDATE;VALUE=PERIOD:20240611T090000Z/PT1H": "periods are not supported",
+	}
+	for prop, want := range tests {
+		_, err := parseICSSchedule(icsDocument("DTSTART:20240611T090000", "DTEND:20240611T180000", prop), time.UTC)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing '%s', got %v", prop, want, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	pb "calendar-scaler/externalscaler"
+)
+
+type S3Metadata struct {
+	Bucket   string `metadata:"bucket,required"`
+	Key      string `metadata:"key,required"`
+	Region   string `metadata:"region"`
+	Endpoint string `metadata:"endpoint"`
+	awsAuth
+	Format       string `metadata:"format"`
+	TimeZone     string `metadata:"timezone,required"`
+	Namespace    string
+	ScaledObject string
+}
+
+func NewS3Metadata(scaledObject *pb.ScaledObjectRef) (*S3Metadata, error) {
+	meta := &S3Metadata{
+		Namespace:    scaledObject.GetNamespace(),
+		ScaledObject: scaledObject.GetName(),
+	}
+	problems := decodeMetadata(scaledObject.GetScalerMetadata(), scaledObject.GetNamespace(), meta, nil)
+	problems.add(meta.validate())
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (meta *S3Metadata) validate() error {
+	problems := validateMetadata(meta)
+	problems.add(meta.awsAuth.validate())
+	format, err := scheduleFormat(meta.Format, meta.Key)
+	problems.add(err)
+	meta.Format = format
+	// Override endpoint if S3_ENDPOINT environment variable is set
+	if meta.Endpoint == "" {
+		meta.Endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	return problems.err()
+}
+
+// s3CacheEntry holds the parsed schedule of an object together with its ETag.
+type s3CacheEntry struct {
+	ETag   string
+	Events []scheduleEvent
+}
+
+// s3Cache keeps the last fetched schedule per object, so unchanged objects are
+// answered with 304 Not Modified instead of being downloaded and parsed again.
+var s3Cache = struct {
+	sync.Mutex
+	entries map[string]s3CacheEntry
+}{entries: map[string]s3CacheEntry{}}
+
+type S3Client struct {
+	Client *s3.Client
+	Meta   *S3Metadata
+}
+
+func NewS3(meta *S3Metadata) (*S3Client, error) {
+	cfg, err := loadAWSConfig(context.TODO(), awsSettings{Region: meta.Region, awsAuth: meta.awsAuth})
+	if err != nil {
+		return nil, err
+	}
+
+	var client *s3.Client
+	if meta.Endpoint != "" {
+		// S3-compatible stores (MinIO, Ceph, ...) generally require path-style addressing
+		client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.BaseEndpoint = &meta.Endpoint
+			o.UsePathStyle = true
+		})
+	} else {
+		client = s3.NewFromConfig(cfg)
+	}
+	return &S3Client{Client: client, Meta: meta}, nil
+}
+
+func (db *S3Client) GetEvents() ([]Event, error) {
+	location, err := time.LoadLocation(db.Meta.TimeZone)
+	if err != nil {
+		fmt.Printf("[S3 Error] failed to load timezone '%s': %v\n", db.Meta.TimeZone, err)
+		return nil, err
+	}
+	schedule, err := db.loadSchedule(location)
+	if err != nil {
+		return nil, err
+	}
+	targetKey := db.Meta.Namespace + "/" + db.Meta.ScaledObject
+	return activeEvents(schedule, time.Now().In(location), targetKey), nil
+}
+
+func (db *S3Client) loadSchedule(location *time.Location) ([]scheduleEvent, error) {
+	cacheKey := db.Meta.Endpoint + "|" + db.Meta.Bucket + "|" + db.Meta.Key + "|" + db.Meta.Format + "|" + location.String()
+	s3Cache.Lock()
+	cached, hasCache := s3Cache.entries[cacheKey]
+	s3Cache.Unlock()
+
+	input := &s3.GetObjectInput{
+		Bucket: &db.Meta.Bucket,
+		Key:    &db.Meta.Key,
+	}
+	if hasCache && cached.ETag != "" {
+		input.IfNoneMatch = aws.String(cached.ETag)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := db.Client.GetObject(ctx, input)
+	if err != nil {
+		var respErr interface{ HTTPStatusCode() int }
+		if hasCache && errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+			return cached.Events, nil
+		}
+		fmt.Printf("[S3 Error] failed to get object 's3://%s/%s': %v\n", db.Meta.Bucket, db.Meta.Key, err)
+		return nil, err
+	}
+	defer result.Body.Close()
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		fmt.Printf("[S3 Error] failed to read object 's3://%s/%s': %v\n", db.Meta.Bucket, db.Meta.Key, err)
+		return nil, err
+	}
+	events, err := parseSchedule(data, db.Meta.Format, location)
+	if err != nil {
+		fmt.Printf("[S3 Parse Error] failed to parse object 's3://%s/%s': %v\n", db.Meta.Bucket, db.Meta.Key, err)
+		return nil, err
+	}
+
+	s3Cache.Lock()
+	s3Cache.entries[cacheKey] = s3CacheEntry{ETag: aws.ToString(result.ETag), Events: events}
+	s3Cache.Unlock()
+	return events, nil
+}
+
+func (db *S3Client) Close() error {
+	// No explicit Close required for S3
+	return nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	pb "calendar-scaler/externalscaler"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewS3Metadata_RequiredFields(t *testing.T) {
+	scaledObject := &pb.ScaledObjectRef{
+		ScalerMetadata: map[string]string{
+			"bucket":   "forecasts",
+			"key":      "daily/schedule.yaml",
+			"timezone": "Asia/Tokyo",
+		},
+	}
+	meta, err := NewS3Metadata(scaledObject)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.Format != "yaml" {
+		t.Errorf("expected format derived from key to be 'yaml', got '%s'", meta.Format)
+	}
+	scaledObject.ScalerMetadata["key"] = "daily/schedule"
+	if _, err := NewS3Metadata(scaledObject); err == nil {
+		t.Error("expected error when format cannot be derived")
+	}
+}
+
+func TestS3Client_GetEventsUsesETag(t *testing.T) {
+	requests, notModified := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/forecasts/schedule.json" {
+			http.NotFound(w, r)
+			return
+		}
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"start":"2000-01-01T00:00:00Z","end":"2999-01-01T00:00:00Z","desiredReplicas":6}]`))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	meta, err := NewS3Metadata(&pb.ScaledObjectRef{
+		ScalerMetadata: map[string]string{
+			"bucket":   "forecasts",
+			"key":      "schedule.json",
+			"region":   "us-east-1",
+			"endpoint": server.URL,
+			"timezone": "UTC",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		client, err := NewS3(meta)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		events, err := client.GetEvents()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(events) != 1 || events[0].DesiredReplicas != 6 {
+			t.Errorf("unexpected events %+v", events)
+		}
+	}
+	if requests != 2 || notModified != 1 {
+		t.Errorf("expected second request to be answered from cache, got %d requests / %d not modified", requests, notModified)
+	}
+}
+
+func TestS3Client_TriggerCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIATEAMA/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`[{"start":"2000-01-01T00:00:00Z","end":"2999-01-01T00:00:00Z","desiredReplicas":4}]`))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAPOD")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "pod")
+
+	metadata := map[string]string{
+		"bucket":         "team-a",
+		"key":            "schedule.json",
+		"region":         "us-east-1",
+		"endpoint":       server.URL,
+		"timezone":       "UTC",
+		"awsAccessKeyId": "AKIATEAMA",
+	}
+	if _, err := NewS3Metadata(&pb.ScaledObjectRef{ScalerMetadata: metadata}); err == nil {
+		t.Error("expected error for awsAccessKeyId without awsSecretAccessKey")
+	}
+	metadata["awsSecretAccessKey"] = "team-a"
+	meta, err := NewS3Metadata(&pb.ScaledObjectRef{ScalerMetadata: metadata})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client, err := NewS3(meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events, err := client.GetEvents()
+	if err != nil {
+		t.Fatalf("expected the credentials of the trigger to be used, got %v", err)
+	}
+	if len(events) != 1 || events[0].DesiredReplicas != 4 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
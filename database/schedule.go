@@ -0,0 +1,483 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Schedules are evaluated in-process by the file based backends (S3, git, inline).
+// Supported formats are JSON, YAML, CSV and iCalendar (ICS).
+
+// scheduleEvent is an event of a schedule together with the scaledobjects it applies to.
+// An event without targets applies to every scaledobject. Recurring events have a
+// cron schedule and duration instead of start/end.
+type scheduleEvent struct {
+	Event
+	Targets  []string
+	Cron     cron.Schedule
+	Duration time.Duration
+}
+
+type scheduleEntry struct {
+	Start           string      `json:"start" yaml:"start"`
+	End             string      `json:"end" yaml:"end"`
+	Cron            string      `json:"cron" yaml:"cron"`
+	Duration        string      `json:"duration" yaml:"duration"`
+	DesiredReplicas int         `json:"desiredReplicas" yaml:"desiredReplicas"`
+	Targets         stringsList `json:"targets" yaml:"targets"`
+}
+
+// stringsList accepts either a list of strings or a single comma-separated string.
+type stringsList []string
+
+func (l *stringsList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*l = splitList(s)
+	return nil
+}
+
+func (l *stringsList) UnmarshalYAML(node *yaml.Node) error {
+	var list []string
+	if err := node.Decode(&list); err == nil {
+		*l = list
+		return nil
+	}
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	*l = splitList(s)
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// scheduleFormat returns the schedule format configured explicitly or derived from the file name.
+func scheduleFormat(format string, name string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(path.Ext(name), ".")
+	}
+	switch strings.ToLower(format) {
+	case "json":
+		return "json", nil
+	case "yaml", "yml":
+		return "yaml", nil
+	case "csv":
+		return "csv", nil
+	case "ics", "ical":
+		return "ics", nil
+	default:
+		return "", fmt.Errorf("unsupported schedule format '%s' (must be json, yaml, csv or ics)", format)
+	}
+}
+
+// parseSchedule parses a schedule document. Timestamps without an offset are interpreted in location.
+func parseSchedule(data []byte, format string, location *time.Location) ([]scheduleEvent, error) {
+	switch format {
+	case "json":
+		return parseEntries(data, location, json.Unmarshal)
+	case "yaml":
+		return parseEntries(data, location, yaml.Unmarshal)
+	case "csv":
+		return parseCSVSchedule(data, location)
+	case "ics":
+		return parseICSSchedule(data, location)
+	default:
+		return nil, fmt.Errorf("unsupported schedule format '%s'", format)
+	}
+}
+
+// parseEntries accepts a list of entries or an object with an "events" list.
+func parseEntries(data []byte, location *time.Location, unmarshal func([]byte, interface{}) error) ([]scheduleEvent, error) {
+	var entries []scheduleEntry
+	if err := unmarshal(data, &entries); err != nil {
+		var doc struct {
+			Events []scheduleEntry `json:"events" yaml:"events"`
+		}
+		if unmarshal(data, &doc) != nil {
+			return nil, err
+		}
+		entries = doc.Events
+	}
+	events := make([]scheduleEvent, 0, len(entries))
+	for i, entry := range entries {
+		event, err := entry.toScheduleEvent(location)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (entry scheduleEntry) toScheduleEvent(location *time.Location) (scheduleEvent, error) {
+	if entry.Cron != "" {
+		schedule, err := parseCron(entry.Cron)
+		if err != nil {
+			return scheduleEvent{}, err
+		}
+		duration, err := parseEventDuration(entry.Duration)
+		if err != nil {
+			return scheduleEvent{}, err
+		}
+		return scheduleEvent{
+			Event:    Event{DesiredReplicas: entry.DesiredReplicas},
+			Targets:  entry.Targets,
+			Cron:     schedule,
+			Duration: duration,
+		}, nil
+	}
+	start, err := parseScheduleTime(entry.Start, location)
+	if err != nil {
+		return scheduleEvent{}, fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := parseScheduleTime(entry.End, location)
+	if err != nil {
+		return scheduleEvent{}, fmt.Errorf("invalid end: %w", err)
+	}
+	return scheduleEvent{
+		Event: Event{
+			StartTime:       start,
+			EndTime:         end,
+			DesiredReplicas: entry.DesiredReplicas,
+		},
+		Targets: entry.Targets,
+	}, nil
+}
+
+var scheduleTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+func parseScheduleTime(value string, location *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range scheduleTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse '%s' as RFC3339 or local time", value)
+}
+
+// parseCSVSchedule reads a CSV file with a header row containing desiredReplicas, start and end
+// (or cron and duration) and optionally targets (a comma-separated list in a quoted field).
+func parseCSVSchedule(data []byte, location *time.Location) ([]scheduleEvent, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["desiredReplicas"]; !ok {
+		return nil, fmt.Errorf("CSV header must contain 'desiredReplicas'")
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+	var events []scheduleEvent
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		desiredReplicas, err := strconv.Atoi(strings.TrimSpace(record[columns["desiredReplicas"]]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid desiredReplicas: %w", line, err)
+		}
+		entry := scheduleEntry{
+			Start:           column(record, "start"),
+			End:             column(record, "end"),
+			Cron:            strings.TrimSpace(column(record, "cron")),
+			Duration:        column(record, "duration"),
+			DesiredReplicas: desiredReplicas,
+			Targets:         splitList(column(record, "targets")),
+		}
+		event, err := entry.toScheduleEvent(location)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// parseICSSchedule reads the VEVENTs of an iCalendar file. The desired replicas come from an
+// X-DESIRED-REPLICAS property or a "desiredReplicas: N" tag in the DESCRIPTION, and the
+// targets from an optional X-TARGETS property. Recurring events are described in rrule.go.
+func parseICSSchedule(data []byte, location *time.Location) ([]scheduleEvent, error) {
+	var events []scheduleEvent
+	var props icsProperties
+	for _, line := range unfoldICSLines(data) {
+		name, prop := parseICSLine(line)
+		switch {
+		case name == "BEGIN" && prop.Value == "VEVENT":
+			props = icsProperties{}
+		case name == "END" && prop.Value == "VEVENT":
+			if props == nil {
+				continue
+			}
+			event, err := icsEvent(props, location)
+			if err != nil {
+				return nil, fmt.Errorf("event '%s': %w", props.get("UID").Value, err)
+			}
+			if event != nil {
+				events = append(events, *event)
+			}
+			props = nil
+		case props != nil:
+			props[name] = append(props[name], prop)
+		}
+	}
+	return events, nil
+}
+
+// icsProperties holds every occurrence of the properties of an event, as
+// EXDATE and RDATE may be repeated.
+type icsProperties map[string][]icsProperty
+
+// get returns the first occurrence of the property.
+func (p icsProperties) get(name string) icsProperty {
+	if len(p[name]) == 0 {
+		return icsProperty{}
+	}
+	return p[name][0]
+}
+
+type icsProperty struct {
+	Params map[string]string
+	Value  string
+}
+
+func unfoldICSLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func parseICSLine(line string) (string, icsProperty) {
+	head, value, _ := strings.Cut(line, ":")
+	parts := strings.Split(head, ";")
+	prop := icsProperty{Params: map[string]string{}, Value: value}
+	for _, param := range parts[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		prop.Params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return strings.ToUpper(parts[0]), prop
+}
+
+func icsEvent(props icsProperties, location *time.Location) (*scheduleEvent, error) {
+	if strings.EqualFold(props.get("STATUS").Value, "CANCELLED") {
+		return nil, nil
+	}
+	start, err := parseICSTime(props.get("DTSTART"), location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DTSTART: %w", err)
+	}
+	// Without DTEND the event lasts for DURATION, one day for a date or no time
+	// at all for a date-time (RFC 5545 3.6.1)
+	var end time.Time
+	switch {
+	case len(props["DTEND"]) > 0:
+		if end, err = parseICSTime(props.get("DTEND"), location); err != nil {
+			return nil, fmt.Errorf("invalid DTEND: %w", err)
+		}
+	case len(props["DURATION"]) > 0:
+		d, err := parseICSDuration(props.get("DURATION").Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DURATION: %w", err)
+		}
+		end = start.Add(d)
+	case isICSDate(props.get("DTSTART")):
+		end = start.AddDate(0, 0, 1)
+	default:
+		end = start
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("DTEND is before DTSTART")
+	}
+	var desiredReplicas int
+	if v, ok := props["X-DESIRED-REPLICAS"]; ok {
+		desiredReplicas, err = strconv.Atoi(strings.TrimSpace(v[0].Value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid X-DESIRED-REPLICAS: %w", err)
+		}
+	} else if n, ok := parseReplicasTag(unescapeICSText(props.get("DESCRIPTION").Value), "desiredReplicas"); ok {
+		desiredReplicas = n
+	} else {
+		return nil, fmt.Errorf("no X-DESIRED-REPLICAS property or desiredReplicas description tag")
+	}
+	event := &scheduleEvent{
+		Event: Event{
+			StartTime:       start,
+			EndTime:         end,
+			DesiredReplicas: desiredReplicas,
+		},
+		Targets: splitList(unescapeICSText(props.get("X-TARGETS").Value)),
+	}
+	if len(props["RRULE"]) > 0 || len(props["RDATE"]) > 0 {
+		recurrence, err := icsEventRecurrence(props, start, location)
+		if err != nil {
+			return nil, err
+		}
+		event.Cron = recurrence
+		event.Duration = end.Sub(start)
+	}
+	return event, nil
+}
+
+// icsEventRecurrence returns the occurrences of an event given by its RRULE,
+// RDATEs and EXDATEs.
+func icsEventRecurrence(props icsProperties, start time.Time, location *time.Location) (*icsRecurrence, error) {
+	recurrence := &icsRecurrence{start: start}
+	if len(props["RRULE"]) > 1 {
+		return nil, fmt.Errorf("invalid RRULE: only one RRULE is supported")
+	}
+	if len(props["RRULE"]) > 0 {
+		var err error
+		if recurrence, err = parseRRule(props.get("RRULE").Value, start); err != nil {
+			return nil, fmt.Errorf("invalid RRULE: %w", err)
+		}
+	}
+	for _, name := range []string{"RDATE", "EXDATE"} {
+		for _, prop := range props[name] {
+			if prop.Params["VALUE"] == "PERIOD" {
+				return nil, fmt.Errorf("invalid %s: periods are not supported", name)
+			}
+			for _, value := range strings.Split(prop.Value, ",") {
+				t, err := parseICSTime(icsProperty{Params: prop.Params, Value: value}, location)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s: %w", name, err)
+				}
+				if name == "RDATE" {
+					recurrence.rdates = append(recurrence.rdates, t)
+				} else {
+					recurrence.exdates = append(recurrence.exdates, t)
+				}
+			}
+		}
+	}
+	return recurrence, nil
+}
+
+func parseICSTime(prop icsProperty, location *time.Location) (time.Time, error) {
+	if prop.Value == "" {
+		return time.Time{}, fmt.Errorf("missing value")
+	}
+	if tzid, ok := prop.Params["TZID"]; ok {
+		loc, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, err
+		}
+		location = loc
+	}
+	if isICSDate(prop) {
+		return time.ParseInLocation("20060102", prop.Value, location)
+	}
+	if strings.HasSuffix(prop.Value, "Z") {
+		return time.Parse("20060102T150405Z", prop.Value)
+	}
+	return time.ParseInLocation("20060102T150405", prop.Value, location)
+}
+
+func isICSDate(prop icsProperty) bool {
+	return prop.Params["VALUE"] == "DATE" || len(prop.Value) == 8
+}
+
+var icsDurationPattern = regexp.MustCompile(`^\+?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseICSDuration parses a DURATION value such as PT1H30M, P1D or P2W.
+func parseICSDuration(value string) (time.Duration, error) {
+	m := icsDurationPattern.FindStringSubmatch(value)
+	if m == nil || strings.HasSuffix(value, "P") || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("'%s' is not a positive duration like PT1H30M or P1D", value)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+1] != "" {
+			n, _ := strconv.Atoi(m[i+1])
+			d += time.Duration(n) * unit
+		}
+	}
+	return d, nil
+}
+
+func unescapeICSText(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// activeEvents returns the events that are active at now and apply to targetKey.
+// Cron expressions are evaluated in the location of now.
+func activeEvents(events []scheduleEvent, now time.Time, targetKey string) []Event {
+	var active []Event
+	for _, event := range events {
+		if len(event.Targets) > 0 && !containsTarget(strings.Join(event.Targets, ","), targetKey) {
+			continue
+		}
+		if event.Cron != nil {
+			start, end, ok := cronOccurrence(event.Cron, event.Duration, now)
+			if !ok {
+				continue
+			}
+			active = append(active, Event{StartTime: start, EndTime: end, DesiredReplicas: event.DesiredReplicas})
+			continue
+		}
+		if now.Before(event.StartTime) || now.After(event.EndTime) {
+			continue
+		}
+		active = append(active, event.Event)
+	}
+	return active
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleFormat(t *testing.T) {
+	cases := map[string]string{
+		"forecast.json": "json",
+		"forecast.yml":  "yaml",
+		"forecast.csv":  "csv",
+		"holidays.ics":  "ics",
+	}
+	for name, expected := range cases {
+		format, err := scheduleFormat("", name)
+		if err != nil || format != expected {
+			t.Errorf("scheduleFormat(%q) = %q, %v; expected %q", name, format, err, expected)
+		}
+	}
+	if _, err := scheduleFormat("", "forecast.txt"); err == nil {
+		t.Error("expected error for unknown extension")
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	location, _ := time.LoadLocation("Asia/Tokyo")
+	documents := map[string]string{
+		"json": `{"events":[{"start":"2024-06-11T12:00:00+09:00","end":"2024-06-11T13:00:00+09:00","desiredReplicas":3,"targets":"default/app"}]}`,
+		"yaml": "- start: 2024-06-11 12:00\n  end: 2024-06-11 13:00\n  desiredReplicas: 3\n  targets: [default/app]\n",
+		"csv":  "start,end,desiredReplicas,targets\n2024-06-11T12:00:00+09:00,2024-06-11T13:00:00+09:00,3,\"default/app,default/other\"\n",
+		"ics": "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART;TZID=Asia/Tokyo:20240611T120000\r\nDTEND:20240611T040000Z\r\n" +
+			"DESCRIPTION:Load test\\ndesiredRep\r\n licas: 3\r\nX-TARGETS:default/app\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	}
+	expectedStart := time.Date(2024, 6, 11, 12, 0, 0, 0, location)
+	for format, doc := range documents {
+		events, err := parseSchedule([]byte(doc), format, location)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", format, err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("%s: expected 1 event, got %d", format, len(events))
+		}
+		if !events[0].StartTime.Equal(expectedStart) || events[0].DesiredReplicas != 3 {
+			t.Errorf("%s: unexpected event %+v", format, events[0])
+		}
+		if len(events[0].Targets) == 0 || events[0].Targets[0] != "default/app" {
+			t.Errorf("%s: unexpected targets %v", format, events[0].Targets)
+		}
+	}
+}
+
+func TestActiveEvents(t *testing.T) {
+	start := time.Date(2024, 6, 11, 12, 0, 0, 0, time.UTC)
+	events := []scheduleEvent{
+		{Event: Event{StartTime: start, EndTime: start.Add(time.Hour), DesiredReplicas: 1}},
+		{Event: Event{StartTime: start, EndTime: start.Add(time.Hour), DesiredReplicas: 2}, Targets: []string{"default/other"}},
+		{Event: Event{StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour), DesiredReplicas: 3}},
+	}
+	active := activeEvents(events, start.Add(30*time.Minute), "default/app")
+	if len(active) != 1 || active[0].DesiredReplicas != 1 {
+		t.Errorf("unexpected active events %+v", active)
+	}
+}
+
+// icsDocument wraps the properties of one event with desired replicas 3.
+func icsDocument(props ...string) []byte {
+	return []byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nX-DESIRED-REPLICAS:3\r\n" +
+		strings.Join(props, "\r\n") + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+}
+
+func TestParseICSSchedule_End(t *testing.T) {
+	location, _ := time.LoadLocation("Asia/Tokyo")
+	tests := []struct {
+		name  string
+		props []string
+		end   time.Time
+	}{
+		{"duration", []string{"DTSTART:20240611T120000", "DURATION:PT1H30M"}, time.Date(2024, 6, 11, 13, 30, 0, 0, location)},
+		{"duration in days", []string{"DTSTART:20240611T120000", "DURATION:P1DT2H"}, time.Date(2024, 6, 12, 14, 0, 0, 0, location)},
+		{"all-day", []string{"DTSTART;VALUE=DATE:20240611"}, time.Date(2024, 6, 12, 0, 0, 0, 0, location)},
+		{"date-time", []string{"DTSTART:20240611T120000"}, time.Date(2024, 6, 11, 12, 0, 0, 0, location)},
+		{"dtend wins", []string{"DTSTART:20240611T120000", "DTEND:20240611T130000", "DURATION:P1D"}, time.Date(2024, 6, 11, 13, 0, 0, 0, location)},
+	}
+	for _, tt := range tests {
+		events, err := parseICSSchedule(icsDocument(tt.props...), location)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if len(events) != 1 || !events[0].EndTime.Equal(tt.end) {
+			t.Errorf("%s: expected end %s, got %+v", tt.name, tt.end, events)
+		}
+	}
+
+	for _, duration := range []string{"1H", "P", "PT", "-PT1H", "PT1.5H"} {
+		_, err := parseICSSchedule(icsDocument("DTSTART:20240611T120000", "DURATION:"+duration), location)
+		if err == nil || !strings.Contains(err.Error(), "invalid DURATION") {
+			t.Errorf("DURATION:%s: expected error, got %v", duration, err)
+		}
+	}
+}
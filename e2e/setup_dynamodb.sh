@@ -16,6 +16,7 @@ aws dynamodb create-table \
   --attribute-definitions AttributeName=EventName,AttributeType=S \
   --key-schema AttributeName=EventName,KeyType=HASH \
   --billing-mode PAY_PER_REQUEST \
+  --stream-specification StreamEnabled=true,StreamViewType=NEW_AND_OLD_IMAGES \
   --endpoint-url http://localhost:8000 || true
 
 # Truncate table (delete all items)
@@ -3,12 +3,17 @@ package main
 import (
 	db "calendar-scaler/database"
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"time"
 
 	pb "calendar-scaler/externalscaler"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -18,12 +23,26 @@ type ExternalScaler struct {
 	pb.UnimplementedExternalScalerServer
 }
 
+// defaultStreamInterval is how often StreamIsActive re-evaluates a trigger
+// when no change notification arrives.
+const defaultStreamInterval = 30 * time.Second
+
 func (e *ExternalScaler) IsActive(ctx context.Context, scaledObject *pb.ScaledObjectRef) (*pb.IsActiveResponse, error) {
+	active, err := isActive(scaledObject)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.IsActiveResponse{
+		Result: active,
+	}, nil
+}
+
+func isActive(scaledObject *pb.ScaledObjectRef) (bool, error) {
 	// Determine if we should scale to zero (default: true)
 	if scaleToZeroParam, exists := scaledObject.GetScalerMetadata()["scaleToZeroOnNoEvents"]; exists {
 		if scaleToZeroParam == "false" {
 			// If explicitly set to false, prevent scale to zero by always returning active=true
-			return &pb.IsActiveResponse{Result: true}, nil
+			return true, nil
 		}
 	}
 
@@ -31,18 +50,16 @@ func (e *ExternalScaler) IsActive(ctx context.Context, scaledObject *pb.ScaledOb
 	databasetype := scaledObject.GetScalerMetadata()["type"]
 	database, err := db.NewDatabase(databasetype, scaledObject)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return false, status.Error(codes.InvalidArgument, err.Error())
 	}
 	defer database.Close()
 
 	events, err := database.GetEvents()
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return false, status.Error(codes.Internal, err.Error())
 	}
 
-	return &pb.IsActiveResponse{
-		Result: events != nil,
-	}, nil
+	return events != nil, nil
 }
 
 func (e *ExternalScaler) GetMetricSpec(context.Context, *pb.ScaledObjectRef) (*pb.GetMetricSpecResponse, error) {
@@ -67,30 +84,137 @@ func (e *ExternalScaler) GetMetrics(_ context.Context, metricRequest *pb.GetMetr
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	maxDesiredReplicas := 0
-	for _, event := range events {
-		if event.DesiredReplicas > maxDesiredReplicas {
-			maxDesiredReplicas = event.DesiredReplicas
-		}
+	aggregation := metricRequest.ScaledObjectRef.GetScalerMetadata()["aggregation"]
+	desiredReplicas, err := db.AggregateDesiredReplicas(events, aggregation)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	return &pb.GetMetricsResponse{
 		MetricValues: []*pb.MetricValue{{
 			MetricName:  "eventTerm",
-			MetricValue: int64(maxDesiredReplicas),
+			MetricValue: int64(desiredReplicas),
 		}},
 	}, nil
 }
 
+// StreamIsActive re-evaluates the trigger every streamInterval and whenever the
+// backend reports a change, and pushes the result when it differs from the last one.
 func (e *ExternalScaler) StreamIsActive(scaledObject *pb.ScaledObjectRef, epsServer pb.ExternalScaler_StreamIsActiveServer) error {
-	return status.Error(codes.Internal, "The external-push is not implemented.")
+	interval := defaultStreamInterval
+	if value := scaledObject.GetScalerMetadata()["streamInterval"]; value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("invalid streamInterval '%s'", value))
+		}
+		interval = d
+	}
+
+	ctx := epsServer.Context()
+	changes, err := subscribe(ctx, scaledObject)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last *bool
+	for {
+		active, err := isActive(scaledObject)
+		if err != nil {
+			fmt.Printf("[Stream Error] %s/%s: %v\n", scaledObject.GetNamespace(), scaledObject.GetName(), err)
+		} else if last == nil || *last != active {
+			if err := epsServer.Send(&pb.IsActiveResponse{Result: active}); err != nil {
+				return err
+			}
+			last = &active
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil
+			}
+		}
+	}
+}
+
+// subscribe returns the change notifications of the backend, or nil if it has none.
+func subscribe(ctx context.Context, scaledObject *pb.ScaledObjectRef) (<-chan struct{}, error) {
+	database, err := db.NewDatabase(scaledObject.GetScalerMetadata()["type"], scaledObject)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer database.Close()
+	notifier, ok := database.(db.Notifier)
+	if !ok {
+		return nil, nil
+	}
+	changes, err := notifier.Changes(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return changes, nil
+}
+
+// installNotifyTrigger implements the install-notify-trigger command, which
+// installs the trigger required by the notifyChannel option of PostgreSQL triggers.
+func installNotifyTrigger(args []string) error {
+	flags := flag.NewFlagSet("install-notify-trigger", flag.ExitOnError)
+	table := flags.String("table", "", "calendar table, optionally schema-qualified")
+	channel := flags.String("channel", "", "notification channel (the notifyChannel of the trigger)")
+	connectionEnv := flags.String("connection-env", "DATABASE_URL", "environment variable containing the connection string")
+	printOnly := flags.Bool("print", false, "print the SQL instead of executing it")
+	flags.Parse(args)
+	if *table == "" || *channel == "" {
+		return fmt.Errorf("-table and -channel are required")
+	}
+	if *printOnly {
+		statements, err := db.NotifyTriggerSQL(*table, *channel)
+		if err != nil {
+			return err
+		}
+		fmt.Print(statements)
+		return nil
+	}
+	dsn := os.Getenv(*connectionEnv)
+	if dsn == "" {
+		return fmt.Errorf("environment variable %s is empty", *connectionEnv)
+	}
+	if err := db.InstallNotifyTrigger(dsn, *table, *channel); err != nil {
+		return err
+	}
+	fmt.Printf("installed notify trigger on %s (channel %s)\n", *table, *channel)
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "install-notify-trigger" {
+		if err := installNotifyTrigger(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	grpcServer := grpc.NewServer()
 	lis, _ := net.Listen("tcp", ":6000")
 	pb.RegisterExternalScalerServer(grpcServer, &ExternalScaler{})
 
+	metricsAddress := os.Getenv("METRICS_ADDRESS")
+	if metricsAddress == "" {
+		metricsAddress = ":8080"
+	}
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		fmt.Printf("serving metrics on %s\n", metricsAddress)
+		if err := http.ListenAndServe(metricsAddress, mux); err != nil {
+			log.Printf("metrics server stopped: %v", err)
+		}
+	}()
+
 	fmt.Println("listenting on :6000")
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatal(err)